@@ -0,0 +1,291 @@
+// HttpPool 可以设置 Authenticator 和按分组的 ACL, 在查找分组之前完成检查:
+// 没有凭据或凭据无效返回 401, 没有权限返回 403, 拒绝的请求都会记录审计日志。
+// 节点之间使用共享密钥签名的 HMAC token (HMACAuth 同时负责签名和校验), 客户端使用 bearer token。
+// 通过 HMAC 认证的节点拥有所有分组的全部权限。
+package ruoCache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求中没有对应的凭据, 可以交给下一个 Authenticator
+var ErrNoCredentials = errors.New("no credentials")
+
+// 认证通过的请求方
+type Principal struct {
+	Name string
+	Peer bool // 集群中的其他节点
+}
+
+// 认证请求
+type Authenticator interface {
+	// 没有对应的凭据时返回 ErrNoCredentials, 凭据无效时返回其他错误
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// 给发往其他节点的请求签名
+type RequestSigner interface {
+	Sign(r *http.Request) error
+}
+
+type authenticators []Authenticator
+
+// 依次尝试多个 Authenticator, 返回第一个找到凭据的结果
+func Authenticators(auths ...Authenticator) Authenticator {
+	return authenticators(auths)
+}
+
+func (a authenticators) Authenticate(r *http.Request) (*Principal, error) {
+	for _, auth := range a {
+		p, err := auth.Authenticate(r)
+		if err != ErrNoCredentials {
+			return p, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+const (
+	hmacScheme     = "RuoCache-HMAC"
+	bodyHashHeader = "X-Ruocache-Content-Sha256"
+	defaultMaxSkew = 5 * time.Minute
+)
+
+// 节点之间共享密钥的 HMAC-SHA256 签名, token 的格式为 "RuoCache-HMAC <节点>:<unix 秒>:<签名>",
+// 从右边解析, 节点名称中可以有 ':'。签名覆盖请求方法、路径、查询参数、请求体的 SHA-256、
+// 时间和节点名称, 截获的 token 不能换一个请求体重放。超过 MaxSkew 的 token 视为过期。
+// 请求体的 SHA-256 放在 X-Ruocache-Content-Sha256 中, 校验时先只用请求头验证签名,
+// 通过之后才读取请求体核对, 没有密钥的客户端不能让节点缓存大的请求体
+type HMACAuth struct {
+	Name    string // 自己的节点名称
+	Secret  []byte
+	MaxSkew time.Duration // 为 0 时使用 5 分钟
+}
+
+func (a *HMACAuth) signature(r *http.Request, name, ts, bodyHash string) string {
+	mac := hmac.New(sha256.New, a.Secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%s", r.Method, r.URL.EscapedPath(), r.URL.RawQuery, bodyHash, ts, name)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 读出请求体计算 SHA-256, 再把请求体放回去。请求体最多 maxRequestSize 字节
+func hashBody(r *http.Request) (string, error) {
+	h := sha256.New()
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+		r.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		if len(body) > maxRequestSize {
+			return "", fmt.Errorf("request body too large: more than %d bytes", maxRequestSize)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (a *HMACAuth) Sign(r *http.Request) error {
+	bodyHash, err := hashBody(r)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(bodyHashHeader, bodyHash)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	r.Header.Set("Authorization", fmt.Sprintf("%s %s:%s:%s", hmacScheme, a.Name, ts, a.signature(r, a.Name, ts, bodyHash)))
+	return nil
+}
+
+func (a *HMACAuth) Authenticate(r *http.Request) (*Principal, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), hmacScheme+" ")
+	if token == r.Header.Get("Authorization") {
+		return nil, ErrNoCredentials
+	}
+	i := strings.LastIndexByte(token, ':')
+	j := -1
+	if i > 0 {
+		j = strings.LastIndexByte(token[:i], ':')
+	}
+	if j <= 0 {
+		return nil, errors.New("malformed hmac token")
+	}
+	name, ts, sig := token[:j], token[j+1:i], token[i+1:]
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errors.New("malformed hmac token")
+	}
+	skew := a.MaxSkew
+	if skew == 0 {
+		skew = defaultMaxSkew
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > skew || d < -skew {
+		return nil, errors.New("hmac token expired")
+	}
+	declared := r.Header.Get(bodyHashHeader)
+	if declared == "" {
+		return nil, errors.New("missing " + bodyHashHeader)
+	}
+	if !hmac.Equal([]byte(sig), []byte(a.signature(r, name, ts, declared))) {
+		return nil, errors.New("bad hmac signature")
+	}
+	bodyHash, err := hashBody(r)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(bodyHash), []byte(declared)) {
+		return nil, errors.New("request body does not match the signature")
+	}
+	return &Principal{Name: name, Peer: true}, nil
+}
+
+var (
+	_ Authenticator = (*HMACAuth)(nil)
+	_ RequestSigner = (*HMACAuth)(nil)
+)
+
+// 客户端使用的 bearer token, key 是 token, value 是对应的名称
+type BearerTokens map[string]string
+
+func (t BearerTokens) Authenticate(r *http.Request) (*Principal, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == r.Header.Get("Authorization") {
+		return nil, ErrNoCredentials
+	}
+	for known, name := range t {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			return &Principal{Name: name}, nil
+		}
+	}
+	return nil, errors.New("unknown bearer token")
+}
+
+// 分组的权限, 高的权限包含低的权限
+type Permission int
+
+const (
+	PermRead  Permission = iota + 1 // 读取缓存
+	PermWrite                       // 写入、删除缓存
+	PermAdmin                       // 迁移缓存等管理操作
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermRead:
+		return "read"
+	case PermWrite:
+		return "write"
+	case PermAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// 按分组授权, 分组或名称为 "*" 时匹配所有
+type ACL struct {
+	mutex sync.RWMutex
+	rules map[string]map[string]Permission // group -> principal -> permission
+}
+
+func NewACL() *ACL {
+	return &ACL{rules: make(map[string]map[string]Permission)}
+}
+
+// 授予 principal 在 group 上的权限
+func (a *ACL) Grant(principal, group string, perm Permission) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.rules[group] == nil {
+		a.rules[group] = make(map[string]Permission)
+	}
+	a.rules[group][principal] = perm
+}
+
+// 是否允许 p 在 group 上执行需要 perm 的操作
+func (a *ACL) Allowed(p *Principal, group string, perm Permission) bool {
+	if p.Peer {
+		return true
+	}
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	for _, g := range []string{group, "*"} {
+		for _, name := range []string{p.Name, "*"} {
+			if a.rules[g][name] >= perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 设置认证和授权, acl 为空时认证通过即可访问所有分组
+func (p *HttpPool) SetAuth(auth Authenticator, acl *ACL) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.auth = auth
+	p.acl = acl
+}
+
+// 设置发往其他节点的请求的签名, 需要在 Set 之前调用
+func (p *HttpPool) SetSigner(signer RequestSigner) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.signer = signer
+	p.client = nil
+}
+
+// 认证并检查 group 上的权限, 不通过时返回 401 或 403 并记录审计日志
+func (p *HttpPool) authorize(w http.ResponseWriter, r *http.Request, group string, perm Permission) bool {
+	p.mutex.Lock()
+	auth, acl := p.auth, p.acl
+	p.mutex.Unlock()
+	if auth == nil {
+		return true
+	}
+	principal, err := auth.Authenticate(r)
+	if err != nil {
+		p.audit(r, "-", group, perm, err.Error())
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if acl != nil && !acl.Allowed(principal, group, perm) {
+		p.audit(r, principal.Name, group, perm, "permission denied")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (p *HttpPool) audit(r *http.Request, principal, group string, perm Permission, reason string) {
+	log.Printf("[RuoCache] audit: deny %s %s from %s principal=%s group=%s need=%s: %s",
+		r.Method, r.URL.Path, r.RemoteAddr, principal, group, perm, reason)
+}
+
+// 给请求签名后再发送
+type signingTransport struct {
+	base   http.RoundTripper
+	signer RequestSigner
+}
+
+func (t *signingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context()) // RoundTripper 不能修改原来的请求
+	if err := t.signer.Sign(r); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,169 @@
+package ruoCache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthAndACL(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	secret := []byte("cluster secret")
+	acl := NewACL()
+	acl.Grant("alice", "scores", PermRead)
+	acl.Grant("admin", "*", PermAdmin)
+	tokens := BearerTokens{"alice-token": "alice", "admin-token": "admin"}
+
+	type node struct {
+		server *httptest.Server
+		pool   *HttpPool
+		group  *Group
+	}
+	newNode := func(name string) *node {
+		n := &node{}
+		registry := NewRegistry()
+		n.group = registry.NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			return []byte("value of " + key), nil
+		}))
+		n.pool = NewHttpPoolWithRegistry("", registry)
+		peerAuth := &HMACAuth{Name: name, Secret: secret}
+		n.pool.SetAuth(Authenticators(peerAuth, tokens), acl)
+		n.pool.SetSigner(peerAuth)
+		n.server = httptest.NewServer(n.pool)
+		t.Cleanup(n.server.Close)
+		n.pool.self = n.server.URL
+		n.group.RegisterPeers(n.pool)
+		return n
+	}
+	a, b := newNode("a"), newNode("b")
+	a.pool.Set(a.server.URL, b.server.URL)
+	b.pool.Set(a.server.URL, b.server.URL)
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := a.pool.PickPeer(strconv.Itoa(i)); ok {
+			key = strconv.Itoa(i)
+		}
+	}
+	if v, err := a.group.Get(key); err != nil || v.String() != "value of "+key {
+		t.Fatalf("signed peer request failed: %v", err)
+	}
+	if err := a.group.InvalidateTag("t"); err != nil {
+		t.Fatalf("peers should be allowed to invalidate tags: %v", err)
+	}
+
+	request := func(method, path, auth string) int {
+		req, _ := http.NewRequest(method, b.server.URL+defaultBasePath+path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	forged := &HMACAuth{Name: "a", Secret: []byte("wrong secret")}
+	stale := &HMACAuth{Name: "a", Secret: secret}
+	staleReq, _ := http.NewRequest(http.MethodGet, b.server.URL+defaultBasePath+"scores/tom", nil)
+	ts := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	emptyHash, _ := hashBody(staleReq)
+	staleToken := hmacScheme + " a:" + ts + ":" + stale.signature(staleReq, "a", ts, emptyHash)
+	forgedReq, _ := http.NewRequest(http.MethodGet, b.server.URL+defaultBasePath+"scores/tom", nil)
+	forged.Sign(forgedReq)
+
+	tests := []struct {
+		method, path, auth string
+		status             int
+	}{
+		{http.MethodGet, "scores/tom", "", http.StatusUnauthorized},
+		{http.MethodGet, "scores/tom", "Bearer nope", http.StatusUnauthorized},
+		{http.MethodGet, "scores/tom", forgedReq.Header.Get("Authorization"), http.StatusUnauthorized},
+		{http.MethodGet, "scores/tom", staleToken, http.StatusUnauthorized},
+		{http.MethodGet, "scores/tom", "Bearer alice-token", http.StatusOK},
+		{http.MethodGet, "_scan/scores", "Bearer alice-token", http.StatusForbidden},
+		{http.MethodGet, "_scan/scores", "Bearer admin-token", http.StatusOK},
+		{http.MethodPost, "_tag/scores", "Bearer alice-token", http.StatusForbidden},
+		{http.MethodPost, "_handoff/scores", "Bearer alice-token", http.StatusForbidden},
+		{http.MethodGet, "missing/tom", "Bearer alice-token", http.StatusForbidden},
+		{http.MethodGet, "missing/tom", "Bearer admin-token", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if status := request(tt.method, tt.path, tt.auth); status != tt.status {
+			t.Errorf("%s %s with %q: expect %d, got %d", tt.method, tt.path, tt.auth, tt.status, status)
+		}
+	}
+
+	if n := strings.Count(logs.String(), "audit: deny"); n != 8 {
+		t.Fatalf("expect 8 audit records, got %d", n)
+	}
+	if !strings.Contains(logs.String(), "principal=alice group=missing need=read") {
+		t.Fatalf("audit log should record the principal and group")
+	}
+}
+
+func TestHMACAuthBody(t *testing.T) {
+	// 节点名称中带有 ':' 也能正确解析
+	auth := &HMACAuth{Name: "http://10.0.0.1:8001", Secret: []byte("cluster secret")}
+	newRequest := func(body string) *http.Request {
+		r, _ := http.NewRequest(http.MethodPost, "http://10.0.0.2:8001"+defaultBasePath+"_tag/scores", strings.NewReader(body))
+		return r
+	}
+
+	signed := newRequest("tag=a")
+	if err := auth.Sign(signed); err != nil {
+		t.Fatal(err)
+	}
+	p, err := auth.Authenticate(signed)
+	if err != nil || p.Name != auth.Name || !p.Peer {
+		t.Fatalf("expect the signed request to pass, got %v %v", p, err)
+	}
+	if body, _ := ioutil.ReadAll(signed.Body); string(body) != "tag=a" {
+		t.Fatalf("body should be readable after authentication, got %q", body)
+	}
+
+	// 截获的 token 换一个请求体后不能通过
+	tampered := newRequest("tag=b")
+	tampered.Header.Set("Authorization", signed.Header.Get("Authorization"))
+	if _, err := auth.Authenticate(tampered); err == nil {
+		t.Fatalf("expect a tampered body to be rejected")
+	}
+
+	// 换掉请求体的 hash 后签名不对, 不会读取请求体
+	forged := newRequest("")
+	forged.Body = ioutil.NopCloser(unreadable{t})
+	forged.Header = signed.Header.Clone()
+	forged.Header.Set(bodyHashHeader, strings.Repeat("0", 64))
+	if _, err := auth.Authenticate(forged); err == nil || !strings.Contains(err.Error(), "bad hmac signature") {
+		t.Fatalf("expect a bad signature before reading the body, got %v", err)
+	}
+
+	for _, token := range []string{"", ":1:sig", "a:sig", "abc"} {
+		r := newRequest("")
+		r.Header.Set("Authorization", hmacScheme+" "+token)
+		if _, err := auth.Authenticate(r); err == nil {
+			t.Errorf("expect malformed token %q to be rejected", token)
+		}
+	}
+}
+
+// 被读取时让测试失败的请求体
+type unreadable struct {
+	t *testing.T
+}
+
+func (u unreadable) Read(p []byte) (int, error) {
+	u.t.Fatalf("request body should not be read")
+	return 0, nil
+}
@@ -1,26 +1,257 @@
 package ruoCache
 
-//抽象了一个只读数据结构 ByteView 用来表示缓存值，主要的数据结构之一。
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// 抽象了一个只读数据结构 ByteView 用来表示缓存值，主要的数据结构之一。
 type ByteView struct {
-	b []byte // 选择 byte 类型是为了能够支持任意的数据类型的存储，例如字符串、图片等。
+	b       []byte   // 选择 byte 类型是为了能够支持任意的数据类型的存储，例如字符串、图片等。
+	chunks  [][]byte // 大 value 分块保存, 不需要连续的内存, 此时 b 为空
+	n       int      // 分块保存时的总长度
+	version uint64   // 版本号, 每次写入都会变大
 }
 
 // 返回view的长度
 func (v ByteView) Len() int {
+	if v.chunks != nil {
+		return v.n
+	}
 	return len(v.b)
 }
 
-// 
 func (v *ByteView) ByteSlice() []byte {
+	if v.chunks != nil {
+		b := make([]byte, v.n)
+		v.Copy(b)
+		return b
+	}
 	return cloneBytes(v.b)
 }
 
+// 返回写入时的版本号
+func (v ByteView) Version() uint64 {
+	return v.version
+}
+
 func (v ByteView) String() string {
+	if v.chunks != nil {
+		var sb strings.Builder
+		sb.Grow(v.n)
+		for _, c := range v.chunks {
+			sb.Write(c)
+		}
+		return sb.String()
+	}
 	return string(v.b)
 }
 
+// 是否分块保存
+func (v ByteView) chunked() bool {
+	return v.chunks != nil
+}
+
+// 返回连续的数据, 调用方不能修改。分块保存时需要复制一份
+func (v ByteView) contiguous() []byte {
+	if v.chunks != nil {
+		return v.ByteSlice()
+	}
+	return v.b
+}
+
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
 	copy(c, b)
 	return c
 }
+
+// 返回第 i 个字节
+func (v ByteView) At(i int) byte {
+	if v.chunks == nil {
+		return v.b[i]
+	}
+	for _, c := range v.chunks {
+		if i < len(c) {
+			return c[i]
+		}
+		i -= len(c)
+	}
+	panic("ruoCache: ByteView index out of range")
+}
+
+// 返回 [from, to) 之间的数据, 和 v 共享内存
+func (v ByteView) Slice(from, to int) ByteView {
+	if v.chunks == nil {
+		return ByteView{b: v.b[from:to], version: v.version}
+	}
+	if from < 0 || to > v.n || from > to {
+		panic("ruoCache: ByteView slice bounds out of range")
+	}
+	var chunks [][]byte
+	off := 0
+	for _, c := range v.chunks {
+		start, end := off, off+len(c)
+		off = end
+		if end <= from || start >= to {
+			continue
+		}
+		lo, hi := 0, len(c)
+		if from > start {
+			lo = from - start
+		}
+		if to < end {
+			hi = to - start
+		}
+		chunks = append(chunks, c[lo:hi])
+	}
+	switch len(chunks) {
+	case 0:
+		return ByteView{b: []byte{}, version: v.version}
+	case 1:
+		return ByteView{b: chunks[0], version: v.version}
+	}
+	return ByteView{chunks: chunks, n: to - from, version: v.version}
+}
+
+// 内容是否相同, 不比较版本号
+func (v ByteView) Equal(b2 ByteView) bool {
+	if v.Len() != b2.Len() {
+		return false
+	}
+	if b2.chunks == nil {
+		return v.equalBytes(b2.b)
+	}
+	off := 0
+	for _, c := range b2.chunks {
+		if !v.Slice(off, off+len(c)).equalBytes(c) {
+			return false
+		}
+		off += len(c)
+	}
+	return true
+}
+
+func (v ByteView) equalBytes(b []byte) bool {
+	if v.chunks == nil {
+		return bytes.Equal(v.b, b)
+	}
+	if v.n != len(b) {
+		return false
+	}
+	for _, c := range v.chunks {
+		if !bytes.Equal(c, b[:len(c)]) {
+			return false
+		}
+		b = b[len(c):]
+	}
+	return true
+}
+
+// 内容是否和 s 相同
+func (v ByteView) EqualString(s string) bool {
+	if v.chunks == nil {
+		return string(v.b) == s
+	}
+	if v.n != len(s) {
+		return false
+	}
+	for _, c := range v.chunks {
+		if string(c) != s[:len(c)] {
+			return false
+		}
+		s = s[len(c):]
+	}
+	return true
+}
+
+// 复制到 dest, 返回复制的字节数
+func (v ByteView) Copy(dest []byte) int {
+	return v.copyAt(dest, 0)
+}
+
+// 从 off 开始复制到 dest
+func (v ByteView) copyAt(dest []byte, off int) int {
+	if v.chunks == nil {
+		return copy(dest, v.b[off:])
+	}
+	n := 0
+	for _, c := range v.chunks {
+		if off >= len(c) {
+			off -= len(c)
+			continue
+		}
+		n += copy(dest[n:], c[off:])
+		off = 0
+		if n == len(dest) {
+			break
+		}
+	}
+	return n
+}
+
+// 返回一个读取内容的 io.ReadSeeker
+func (v ByteView) Reader() io.ReadSeeker {
+	if v.chunks == nil {
+		return bytes.NewReader(v.b)
+	}
+	return &chunkReader{v: v}
+}
+
+// 把内容写入 w, 实现 io.WriterTo
+func (v ByteView) WriteTo(w io.Writer) (int64, error) {
+	if v.chunks == nil {
+		return writeFull(w, v.b)
+	}
+	var total int64
+	for _, c := range v.chunks {
+		n, err := writeFull(w, c)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func writeFull(w io.Writer, b []byte) (int64, error) {
+	n, err := w.Write(b)
+	if err == nil && n != len(b) {
+		err = io.ErrShortWrite
+	}
+	return int64(n), err
+}
+
+// 分块保存的 ByteView 的 io.ReadSeeker
+type chunkReader struct {
+	v   ByteView
+	off int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.off >= int64(r.v.n) {
+		return 0, io.EOF
+	}
+	n := r.v.copyAt(p, int(r.off))
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += int64(r.v.n)
+	default:
+		return 0, errors.New("ruoCache: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("ruoCache: negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
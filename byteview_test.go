@@ -0,0 +1,83 @@
+package ruoCache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestByteView(t *testing.T) {
+	v := ByteView{b: []byte("ruoCache"), version: 7}
+	if v.At(0) != 'r' || v.At(7) != 'e' {
+		t.Fatalf("At returns wrong bytes")
+	}
+	s := v.Slice(3, 8)
+	if !s.EqualString("Cache") || s.Version() != 7 {
+		t.Fatalf("expect Cache, got %s", s)
+	}
+	if !v.Equal(ByteView{b: []byte("ruoCache")}) || v.Equal(s) || v.EqualString("ruo") {
+		t.Fatalf("Equal compares the wrong content")
+	}
+
+	dest := make([]byte, 3)
+	if n := v.Copy(dest); n != 3 || string(dest) != "ruo" {
+		t.Fatalf("Copy got %d %q", n, dest)
+	}
+
+	r := v.Reader()
+	if _, err := r.Seek(3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := ioutil.ReadAll(r)
+	if string(rest) != "Cache" {
+		t.Fatalf("Reader got %q", rest)
+	}
+
+	var buf bytes.Buffer
+	if n, err := v.WriteTo(&buf); err != nil || n != 8 || buf.String() != "ruoCache" {
+		t.Fatalf("WriteTo got %d %q %v", n, buf.String(), err)
+	}
+}
+
+var benchValue = ByteView{b: bytes.Repeat([]byte("x"), 1<<20)}
+
+func BenchmarkByteSlice(b *testing.B) {
+	b.SetBytes(int64(benchValue.Len()))
+	for i := 0; i < b.N; i++ {
+		benchValue.ByteSlice()
+	}
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	b.SetBytes(int64(benchValue.Len()))
+	for i := 0; i < b.N; i++ {
+		benchValue.WriteTo(ioutil.Discard)
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	b.SetBytes(int64(benchValue.Len()))
+	for i := 0; i < b.N; i++ {
+		io.Copy(ioutil.Discard, benchValue.Reader())
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	registry := NewRegistry()
+	g := registry.NewGroup("bench", 0, GetterFunc(func(key string) ([]byte, error) {
+		return benchValue.b, nil
+	}))
+	g.SetHotKeyPolicy(0, 0)
+	g.Get("large")
+	pool := NewHttpPoolWithRegistry("self", registry)
+	req := httptest.NewRequest(http.MethodGet, defaultBasePath+"bench/large", nil)
+
+	b.SetBytes(int64(benchValue.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pool.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
@@ -1,48 +1,369 @@
 package ruoCache
 
 import (
-	"log"
+	"errors"
 	"ruoCache/lru"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 //region cache
 
 type cache struct {
-	mutex      sync.Mutex
-	lru        *lru.Cache
-	cacheBytes int64
+	mutex         sync.Mutex
+	lru           *lru.Cache
+	cacheBytes    int64
+	entryOverhead int64                          // lru 中每个条目的额外开销, 见 lru.DefaultEntryOverhead
+	tags          map[string]map[string]struct{} // tag -> keys
+	policy        lru.Policy
+	logger        Logger      // 为空时使用标准库的 log
+	notify        func(Event) // 缓存变化时调用, 可以为空
+	onAdd         func()      // 添加缓存并释放锁之后调用, 可以为空
+
+	used  int64  // 当前占用的内存, 在锁内修改, 可以不加锁读取
+	usage *int64 // 所属 Registry 中所有缓存占用的内存, 在锁内修改, 可以为空
+}
+
+// 缓存的淘汰策略
+type EvictionPolicy int
+
+const (
+	EvictLRU  EvictionPolicy = iota // 淘汰最久未访问的缓存
+	EvictFIFO                       // 淘汰最早写入的缓存, 访问不影响淘汰顺序
+)
+
+// 缓存中实际存储的值, 存入之后不再修改
+type cacheEntry struct {
+	value  ByteView
+	expire time.Time // 零值表示永不过期
+	tags   []string
+
+	compressor Compressor // 不为空时 value 是压缩后的数据
+	envelope   *envelope  // 不为空时 value 是加密后的数据
+}
+
+func (e *cacheEntry) Len() int {
+	return e.value.Len()
+}
+
+// Cost implements lru.Coster, 包括 cacheEntry 本身、分块和 tag 占用的内存
+func (e *cacheEntry) Cost() int64 {
+	n := int64(e.value.Len()) + int64(unsafe.Sizeof(*e))
+	n += int64(len(e.value.chunks)) * int64(unsafe.Sizeof([]byte(nil)))
+	for _, tag := range e.tags {
+		n += int64(len(tag)) + int64(unsafe.Sizeof(tag))
+	}
+	return n
+}
+
+// 返回解密、解压后的值, key 是加密时的附加数据
+func (e *cacheEntry) view(key string) (ByteView, error) {
+	value := e.value
+	if e.envelope != nil {
+		b, err := e.envelope.open(key, value.contiguous())
+		if err != nil {
+			return ByteView{}, err
+		}
+		value = ByteView{b: b, version: value.version}
+	}
+	if e.compressor != nil {
+		b, err := e.compressor.Decompress(value.contiguous())
+		if err != nil {
+			return ByteView{}, err
+		}
+		value = ByteView{b: b, version: value.version}
+	}
+	return value, nil
+}
+
+func (e *cacheEntry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// 延迟初始化, 调用方需要持有锁
+func (c *cache) lazyInit() {
+	if c.lru == nil {
+		c.lru = lru.New(c.cacheBytes, c.onEvicted)
+		c.lru.EntryOverhead = c.entryOverhead
+		c.lru.Policy = c.policy
+		c.tags = make(map[string]map[string]struct{})
+	}
+}
+
+// 写入缓存并更新 tag 索引, 调用方需要持有锁
+func (c *cache) put(key string, e *cacheEntry) {
+	c.lazyInit()
+	if old, ok := c.lru.Get(key); ok {
+		c.unindex(key, old.(*cacheEntry).tags)
+	}
+	for _, tag := range e.tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][key] = struct{}{}
+	}
+	c.lru.Add(key, e)
+	c.account()
+	c.publish(Event{Type: EventSet, Key: key, entry: e})
+}
+
+// 删除缓存并更新 tag 索引, 调用方需要持有锁
+func (c *cache) delete(key string, reason EventType) {
+	if old, ok := c.lru.Get(key); ok {
+		c.unindex(key, old.(*cacheEntry).tags)
+		c.lru.Remove(key)
+		c.account()
+		c.publish(Event{Type: reason, Key: key})
+	}
+}
+
+// lru 淘汰缓存时清理 tag 索引, 在 lru.Add 中调用, 此时已经持有锁
+func (c *cache) onEvicted(key string, value lru.Value) {
+	c.unindex(key, value.(*cacheEntry).tags)
+	c.publish(Event{Type: EventEvict, Key: key})
+}
+
+func (c *cache) publish(ev Event) {
+	if c.notify != nil {
+		c.notify(ev)
+	}
+}
+
+func (c *cache) unindex(key string, tags []string) {
+	for _, tag := range tags {
+		if keys, ok := c.tags[tag]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(c.tags, tag)
+			}
+		}
+	}
 }
 
 // 添加缓存
 func (c *cache) add(key string, value ByteView) {
+	c.set(key, &cacheEntry{value: value})
+}
+
+// 添加缓存
+func (c *cache) set(key string, e *cacheEntry) {
+	c.mutex.Lock()
+	c.put(key, e)
+	c.mutex.Unlock()
+	logf(c.logger, "add cache key %s", key) // 不能打印 value, 可能是敏感数据
+	c.added()
+}
+
+// 只有版本号比已有的缓存新时才添加, 返回是否添加成功
+func (c *cache) addIfNewer(key string, e *cacheEntry) bool {
+	c.mutex.Lock()
+	c.lazyInit()
+	old, ok := c.peek(key)
+	if ok && old.value.version >= e.value.version {
+		c.mutex.Unlock()
+		return false
+	}
+	c.put(key, e)
+	c.mutex.Unlock()
+	logf(c.logger, "add cache key %s", key)
+	c.added()
+	return true
+}
+
+// 已有缓存的版本号等于 expected 时才添加, expected 为 0 表示缓存必须不存在
+func (c *cache) compareAndAdd(key string, expected uint64, e *cacheEntry) bool {
+	c.mutex.Lock()
+	c.lazyInit()
+	var current uint64
+	if old, ok := c.peek(key); ok {
+		current = old.value.version
+	}
+	if current != expected {
+		c.mutex.Unlock()
+		return false
+	}
+	c.put(key, e)
+	c.mutex.Unlock()
+	c.added()
+	return true
+}
+
+// 添加之后的回调, 此时没有持有锁, 回调中可以访问其他缓存
+func (c *cache) added() {
+	if c.onAdd != nil {
+		c.onAdd()
+	}
+}
+
+// 当前占用的内存, 不需要加锁
+func (c *cache) bytes() int64 {
+	return atomic.LoadInt64(&c.used)
+}
+
+// lru 的大小变化后更新占用的内存, 调用方需要持有锁
+func (c *cache) account() {
+	var now int64
+	if c.lru != nil {
+		now = c.lru.Bytes()
+	}
+	if delta := now - c.used; delta != 0 {
+		atomic.AddInt64(&c.used, delta)
+		if c.usage != nil {
+			atomic.AddInt64(c.usage, delta)
+		}
+	}
+}
+
+// 开始或停止把占用的内存计入 usage, usage 为空时停止
+func (c *cache) trackUsage(usage *int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.usage != nil {
+		atomic.AddInt64(c.usage, -c.used)
+	}
+	if usage != nil {
+		atomic.AddInt64(usage, c.used)
+	}
+	c.usage = usage
+}
+
+// 修改缓存的上限, 超出的部分立即淘汰
+func (c *cache) resize(cacheBytes int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cacheBytes = cacheBytes
+	if c.lru != nil {
+		c.lru.SetMaxBytes(cacheBytes)
+		c.account()
+	}
+}
+
+// 清空缓存, 释放内存
+func (c *cache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lru = nil
+	c.tags = nil
+	c.account()
+}
+
+// 淘汰最久未使用的缓存, 返回释放的内存
+func (c *cache) removeOldest() int64 {
 	c.mutex.Lock()
-	defer c.mutex.Unlock() // 自动解锁
+	defer c.mutex.Unlock()
+	if c.lru == nil || c.lru.Len() == 0 {
+		return 0
+	}
+	before := c.lru.Bytes()
+	c.lru.RemoveOldest()
+	c.account()
+	return before - c.lru.Bytes()
+}
+
+// 查找未过期的缓存, 调用方需要持有锁
+func (c *cache) peek(key string) (*cacheEntry, bool) {
+	v, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	e := v.(*cacheEntry)
+	if e.expired(time.Now()) {
+		c.delete(key, EventExpire)
+		return nil, false
+	}
+	return e, true
+}
+
+// 获取缓存及其 tag 等信息
+func (c *cache) lookup(key string) (*cacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+		return nil, false
 	}
-	c.lru.Add(key, value)
-	log.Printf("add cache key %s value %s", key, value.String())
+	return c.peek(key)
 }
 
 // 获取缓存
-func (c *cache) get(key string) (value ByteView, ok bool) {
+func (c *cache) get(key string) (ByteView, bool) {
+	e, ok := c.lookup(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	value, err := e.view(key) // 在锁外解密、解压
+	if err != nil {
+		logf(c.logger, "[RuoCache] decode %s failed: %v", key, err)
+		return ByteView{}, false
+	}
+	return value, true
+}
+
+// 删除缓存
+func (c *cache) remove(key string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	if c.lru == nil {
 		return
 	}
+	c.delete(key, EventRemove)
+}
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+// 缓存的版本号没有变化时才删除
+func (c *cache) removeIfVersion(key string, version uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lru == nil {
+		return
+	}
+	if e, ok := c.peek(key); ok && e.value.version == version {
+		c.delete(key, EventRemove)
 	}
+}
+
+// 删除带有 tag 的所有缓存, 返回删除的 key
+func (c *cache) removeTag(tag string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(c.tags[tag]))
+	for key := range c.tags[tag] {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		c.delete(key, EventRemove)
+	}
+	return keys
+}
 
-	return
+// 复制出满足条件的缓存, 避免在持有锁的时候做耗时操作
+func (c *cache) items(filter func(key string) bool) map[string]*cacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	items := make(map[string]*cacheEntry)
+	if c.lru == nil {
+		return items
+	}
+	now := time.Now()
+	c.lru.Range(func(key string, value lru.Value) bool {
+		if e := value.(*cacheEntry); !e.expired(now) && filter(key) {
+			items[key] = e
+		}
+		return true
+	})
+	return items
 }
 
 //endregion cache
 
 // region getter
+
+// Getter 找不到 key 时返回 ErrNotFound 或者包装它的错误, 调用方可以和数据源出错区分开
+var ErrNotFound = errors.New("not found")
+
 type Getter interface {
 	Get(key string) ([]byte, error)
 }
@@ -54,4 +375,23 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// 加载数据的同时返回它的 tag, 之后可以通过 Group.InvalidateTag 一起删除
+type TaggedGetter interface {
+	Getter
+	GetWithTags(key string) ([]byte, []string, error)
+}
+
+type TaggedGetterFunc func(key string) ([]byte, []string, error)
+
+// Get implements Getter interface function
+func (f TaggedGetterFunc) Get(key string) ([]byte, error) {
+	b, _, err := f(key)
+	return b, err
+}
+
+// GetWithTags implements TaggedGetter interface function
+func (f TaggedGetterFunc) GetWithTags(key string) ([]byte, []string, error) {
+	return f(key)
+}
+
 // endregion
@@ -2,7 +2,11 @@ package ruoCache
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"ruoCache/lru"
+	"runtime"
 	"testing"
 )
 
@@ -39,3 +43,68 @@ func TestGet(t *testing.T) {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
+
+func TestCompareAndSet(t *testing.T) {
+	ruo := newGroup("cas", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+
+	v1, err := ruo.CompareAndSet("tom", 0, "630")
+	if err != nil {
+		t.Fatalf("create tom failed: %v", err)
+	}
+	if _, err = ruo.CompareAndSet("tom", 0, "631"); err != ErrVersionMismatch {
+		t.Fatalf("expect version mismatch when tom exists, got %v", err)
+	}
+	v2, err := ruo.CompareAndSet("tom", v1, "640")
+	if err != nil || v2 <= v1 {
+		t.Fatalf("update tom failed: %v, version %d -> %d", err, v1, v2)
+	}
+	if _, err = ruo.CompareAndSet("tom", v1, "650"); err != ErrVersionMismatch {
+		t.Fatalf("expect version mismatch with old version, got %v", err)
+	}
+	if view, _ := ruo.Get("tom"); view.String() != "640" || view.Version() != v2 {
+		t.Fatalf("expect tom=640@%d, got %s@%d", v2, view, view.Version())
+	}
+
+	if err = ruo.SetWithVersion("tom", "600", v2); err != ErrStaleVersion {
+		t.Fatalf("expect stale version, got %v", err)
+	}
+	if err = ruo.SetWithVersion("tom", "700", v2+10); err != nil {
+		t.Fatalf("set with newer version failed: %v", err)
+	}
+	ruo.Set("tom", "800")
+	if view, _ := ruo.Get("tom"); view.Version() <= v2+10 {
+		t.Fatalf("local write should get a version newer than %d, got %d", v2+10, view.Version())
+	}
+}
+
+// 统计的内存与 runtime.MemStats 中实际增加的堆内存相差不超过 25%
+func TestMemoryAccounting(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping memory accounting test in short mode")
+	}
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	const n = 2000000
+	value := []byte("12345678")
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	c := &cache{entryOverhead: lru.DefaultEntryOverhead}
+	for i := 0; i < n; i++ {
+		c.add(fmt.Sprintf("key%07d", i), ByteView{b: cloneBytes(value)})
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	heap := float64(after.HeapAlloc - before.HeapAlloc)
+	tracked := float64(c.lru.Bytes())
+	t.Logf("tracked %.0f bytes, heap grew %.0f bytes", tracked, heap)
+	if ratio := tracked / heap; ratio < 0.75 || ratio > 1.25 {
+		t.Fatalf("tracked bytes are %.2f of the real heap usage", ratio)
+	}
+	runtime.KeepAlive(c)
+}
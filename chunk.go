@@ -0,0 +1,166 @@
+// 超过 chunkSize 的 value 分块保存, 每块单独分配内存, 不需要一整块连续的内存。
+// 节点之间直接以流的形式发送分块的 value, 接收方边读边分块, 不需要先读出整个响应再解码;
+// 调用方也可以通过 Group.GetReader 以 io.Reader 的形式读取, 不用复制出一份完整的 value。
+package ruoCache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	pb "ruoCache/ruoCachePb"
+	"strconv"
+)
+
+const (
+	defaultChunkSize   = 1 << 20
+	chunkedContentType = "application/x-ruocache-chunked"
+	versionHeader      = "X-Ruocache-Version"
+)
+
+// 可以直接以分块的形式返回大 value 的节点
+type ChunkedPeerGetter interface {
+	// 从对应 group 查找缓存值, 超过 chunkSize 时分块保存
+	GetChunked(in *pb.Request, chunkSize int) (ByteView, error)
+}
+
+// 复制 b, 超过 chunkSize 时分块保存
+func newByteView(b []byte, version uint64, chunkSize int) ByteView {
+	if chunkSize <= 0 || len(b) <= chunkSize {
+		return ByteView{b: cloneBytes(b), version: version}
+	}
+	chunks := make([][]byte, 0, (len(b)+chunkSize-1)/chunkSize)
+	for len(b) > 0 {
+		n := chunkSize
+		if n > len(b) {
+			n = len(b)
+		}
+		chunks = append(chunks, cloneBytes(b[:n]))
+		b = b[n:]
+	}
+	return ByteView{chunks: chunks, version: version}.withLen()
+}
+
+// 读出 r 中的所有数据, 超过 chunkSize 时分块保存, 超过 limit 字节时返回错误
+func readByteView(r io.Reader, version uint64, chunkSize int, limit int64) (ByteView, error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	r = io.LimitReader(r, limit+1)
+	var chunks [][]byte
+	var total int64
+	for {
+		buf := make([]byte, chunkSize)
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if n < len(buf) {
+				buf = cloneBytes(buf[:n]) // 不保留最后一块多分配的内存
+			}
+			chunks = append(chunks, buf)
+			if total += int64(n); total > limit {
+				return ByteView{}, fmt.Errorf("value too large: more than %d bytes", limit)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return ByteView{}, err
+		}
+	}
+	switch len(chunks) {
+	case 0:
+		return ByteView{b: []byte{}, version: version}, nil
+	case 1:
+		return ByteView{b: chunks[0], version: version}, nil
+	}
+	return ByteView{chunks: chunks, version: version}.withLen(), nil
+}
+
+// 根据 chunks 计算总长度
+func (v ByteView) withLen() ByteView {
+	v.n = 0
+	for _, c := range v.chunks {
+		v.n += len(c)
+	}
+	return v
+}
+
+// 复制 b 并按分组的 chunkSize 分块
+func (g *Group) newView(b []byte, version uint64) ByteView {
+	return newByteView(b, version, g.chunkSize)
+}
+
+// 直接使用 b, 超过分组的 chunkSize 时复制并分块
+func (g *Group) ownedView(b []byte, version uint64) ByteView {
+	if len(b) > g.chunkSize {
+		return newByteView(b, version, g.chunkSize)
+	}
+	return ByteView{b: b, version: version}
+}
+
+// 以 io.Reader 的形式读取缓存值, 不复制缓存中的数据
+func (g *Group) GetReader(key string) (io.ReadSeeker, error) {
+	view, err := g.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return view.Reader(), nil
+}
+
+// 对方接受分块的响应时直接写入 value, 不经过 protobuf
+func (p *HttpPool) serveChunked(w http.ResponseWriter, r *http.Request, view ByteView) bool {
+	if !view.chunked() || r.Header.Get("Accept") != chunkedContentType {
+		return false
+	}
+	w.Header().Set("Content-Type", chunkedContentType)
+	w.Header().Set(versionHeader, strconv.FormatUint(view.Version(), 10))
+	w.Header().Set("Content-Length", strconv.Itoa(view.Len()))
+	if _, err := view.WriteTo(w); err != nil {
+		p.Log("write chunked response: %v", err)
+	}
+	return true
+}
+
+func (h *httpGetter) GetChunked(in *pb.Request, chunkSize int) (ByteView, error) {
+	req, err := http.NewRequest(http.MethodGet, h.valueURL(in), nil)
+	if err != nil {
+		return ByteView{}, err
+	}
+	req.Header.Set("Accept", chunkedContentType)
+	res, err := h.client.Do(req)
+	if err != nil {
+		return ByteView{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return ByteView{}, fmt.Errorf("%s: %w", in.GetKey(), ErrNotFound)
+	}
+	if res.StatusCode != http.StatusOK {
+		return ByteView{}, fmt.Errorf("server returned: %v", res.Status)
+	}
+	if res.Header.Get("Content-Type") != chunkedContentType {
+		out := &pb.Response{}
+		if err := readResponse(res.Body, out); err != nil {
+			return ByteView{}, err
+		}
+		return ByteView{b: out.GetValue(), version: out.GetVersion()}, nil
+	}
+	version, err := strconv.ParseUint(res.Header.Get(versionHeader), 10, 64)
+	if err != nil {
+		return ByteView{}, fmt.Errorf("bad %s header: %v", versionHeader, err)
+	}
+	if res.ContentLength > maxEntrySize {
+		return ByteView{}, fmt.Errorf("value too large: %d bytes", res.ContentLength)
+	}
+	view, err := readByteView(res.Body, version, chunkSize, maxEntrySize)
+	if err != nil {
+		return ByteView{}, fmt.Errorf("reading chunked response: %v", err)
+	}
+	if res.ContentLength >= 0 && int64(view.Len()) != res.ContentLength {
+		return ByteView{}, fmt.Errorf("chunked response truncated: %d of %d bytes", view.Len(), res.ContentLength)
+	}
+	return view, nil
+}
+
+var _ ChunkedPeerGetter = (*httpGetter)(nil)
@@ -0,0 +1,129 @@
+package ruoCache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestChunkedByteView(t *testing.T) {
+	raw := []byte("0123456789abcdefghij")
+	v := newByteView(raw, 3, 6)
+	if !v.chunked() || len(v.chunks) != 4 || v.Len() != len(raw) || v.Version() != 3 {
+		t.Fatalf("expect 4 chunks of %d bytes, got %d chunks", len(raw), len(v.chunks))
+	}
+	raw[0] = 'x'
+	if v.At(0) != '0' || v.At(6) != '6' || v.At(19) != 'j' {
+		t.Fatalf("At returns wrong bytes")
+	}
+	if v.String() != "0123456789abcdefghij" || string(v.ByteSlice()) != v.String() {
+		t.Fatalf("got %s", v.String())
+	}
+	flat := ByteView{b: []byte("0123456789abcdefghij")}
+	if !v.Equal(flat) || !flat.Equal(v) || !v.Equal(newByteView(flat.b, 0, 7)) || !v.EqualString(flat.String()) {
+		t.Fatalf("chunked view should equal its contiguous copy")
+	}
+	if v.Equal(newByteView([]byte("0123456789abcdefghiX"), 0, 7)) || v.EqualString("0123456789") {
+		t.Fatalf("Equal compares the wrong content")
+	}
+	if s := v.Slice(4, 14); !s.EqualString("456789abcd") || s.Len() != 10 {
+		t.Fatalf("Slice got %s", s)
+	}
+	if s := v.Slice(7, 9); s.chunked() || !s.EqualString("78") {
+		t.Fatalf("Slice within a chunk got %s", s)
+	}
+
+	dest := make([]byte, 8)
+	if n := v.Copy(dest); n != 8 || string(dest) != "01234567" {
+		t.Fatalf("Copy got %d %q", n, dest)
+	}
+	r := v.Reader()
+	if _, err := r.Seek(-5, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := ioutil.ReadAll(r); string(rest) != "fghij" {
+		t.Fatalf("Reader got %q", rest)
+	}
+	var buf bytes.Buffer
+	if n, err := v.WriteTo(&buf); err != nil || n != 20 || buf.String() != flat.String() {
+		t.Fatalf("WriteTo got %d %q %v", n, buf.String(), err)
+	}
+
+	got, err := readByteView(bytes.NewReader(flat.b), 5, 8, maxEntrySize)
+	if err != nil || len(got.chunks) != 3 || !got.Equal(flat) || got.Version() != 5 {
+		t.Fatalf("readByteView got %d chunks, %v", len(got.chunks), err)
+	}
+	// 对方返回的数据超过上限时不会全部读入内存
+	if _, err := readByteView(bytes.NewReader(flat.b), 5, 8, 20); err != nil {
+		t.Fatalf("value of exactly the limit should be accepted: %v", err)
+	}
+	if _, err := readByteView(bytes.NewReader(flat.b), 5, 8, 19); err == nil {
+		t.Fatalf("expect an error when the value exceeds the limit")
+	}
+}
+
+func TestChunkedPeerTransfer(t *testing.T) {
+	value := bytes.Repeat([]byte("ruoCache"), 4<<10)
+	type node struct {
+		server *httptest.Server
+		pool   *HttpPool
+		group  *Group
+		loads  int32
+	}
+	newNode := func() *node {
+		n := &node{}
+		registry := NewRegistry()
+		n.group, _ = registry.NewGroupWithOptions("blobs", GetterFunc(func(key string) ([]byte, error) {
+			atomic.AddInt32(&n.loads, 1)
+			return value, nil
+		}), WithChunkSize(4<<10))
+		n.pool = NewHttpPoolWithRegistry("", registry)
+		n.server = httptest.NewServer(n.pool)
+		t.Cleanup(n.server.Close)
+		n.pool.self = n.server.URL
+		n.group.RegisterPeers(n.pool)
+		return n
+	}
+	a, b := newNode(), newNode()
+	a.pool.Set(a.server.URL, b.server.URL)
+	b.pool.Set(a.server.URL, b.server.URL)
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := a.pool.PickPeer(strconv.Itoa(i)); ok {
+			key = strconv.Itoa(i)
+		}
+	}
+
+	r, err := a.group.GetReader(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(r)
+	if !bytes.Equal(got, value) {
+		t.Fatalf("expect %d bytes, got %d", len(value), len(got))
+	}
+	if a.loads != 0 || b.loads != 1 {
+		t.Fatalf("expect the owner to load once, got a=%d b=%d", a.loads, b.loads)
+	}
+
+	view, err := b.group.Get(key)
+	if err != nil || !view.chunked() || len(view.chunks) != 8 {
+		t.Fatalf("owner should keep the value in chunks")
+	}
+	req, _ := http.NewRequest(http.MethodGet, b.server.URL+defaultBasePath+"blobs/"+key, nil)
+	req.Header.Set("Accept", chunkedContentType)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != chunkedContentType || res.Header.Get(versionHeader) != strconv.FormatUint(view.Version(), 10) {
+		t.Fatalf("expect a chunked response, got %v", res.Header)
+	}
+}
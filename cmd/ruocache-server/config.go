@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"ruoCache"
+	"ruoCache/consistentHash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 环境变量的前缀, 例如 RUOCACHE_LISTEN_API 覆盖 listen.api
+const envPrefix = "RUOCACHE_"
+
+type Config struct {
+	Listen       ListenConfig    `json:"listen"`
+	Peers        PeersConfig     `json:"peers"`
+	MemoryBudget ByteSize        `json:"memory_budget"` // 所有分组共享的内存上限, 0 表示不限制
+	Groups       []GroupConfig   `json:"groups"`
+	Transport    TransportConfig `json:"transport"`
+	Log          LogConfig       `json:"log"`
+}
+
+// 监听的地址, 为空时不启动对应的服务
+type ListenConfig struct {
+	Peer         string `json:"peer"`          // 节点之间通信
+	API          string `json:"api"`           // JSON REST API
+	RESP         string `json:"resp"`          // Redis 协议
+	Memcache     string `json:"memcache"`      // memcached 协议
+	DefaultGroup string `json:"default_group"` // RESP 和 memcached 默认访问的分组, 为空时使用第一个分组
+}
+
+type PeersConfig struct {
+	Self        string   `json:"self"`         // 其他节点访问本节点的地址, 例如 http://10.0.0.1:8001
+	Name        string   `json:"name"`         // 请求签名中的节点名称, 不能包含 ':', 为空时由 self 生成, 例如 10.0.0.1-8001
+	Members     []string `json:"members"`      // 集群中所有节点的地址, 包括 self
+	HandoffRate ByteSize `json:"handoff_rate"` // 节点变化时迁移缓存的速率, 0 表示使用默认值
+	Hash        string   `json:"hash"`         // 一致性hash的算法 xxhash (默认)、fnv1a、murmur3 或 crc32, 所有节点必须一致
+}
+
+type GroupConfig struct {
+	Name          string       `json:"name"`
+	CacheBytes    ByteSize     `json:"cache_bytes"`
+	HotCacheBytes ByteSize     `json:"hot_cache_bytes"` // 0 表示使用 cache_bytes 的 1/8
+	MinBytes      ByteSize     `json:"min_bytes"`       // 共享内存时至少保留的大小
+	TTL           Duration     `json:"ttl"`             // 0 表示永不过期
+	ChunkSize     ByteSize     `json:"chunk_size"`      // 0 表示使用默认值
+	Compression   string       `json:"compression"`     // "", "flate" 或 "gzip"
+	Eviction      string       `json:"eviction"`        // "" 或 "lru" 按最近访问淘汰, "fifo" 按写入顺序淘汰
+	HotKey        HotKeyConfig `json:"hot_key"`
+	Load          LoadConfig   `json:"load"`
+}
+
+type HotKeyConfig struct {
+	Disabled  bool     `json:"disabled"`
+	Threshold uint64   `json:"threshold"` // 和 lease 都为 0 时使用默认值
+	Lease     Duration `json:"lease"`
+}
+
+// 从数据源加载的限制, 0 表示不限制
+type LoadConfig struct {
+	Concurrency int      `json:"concurrency"`
+	Rate        float64  `json:"rate"`
+	Burst       int      `json:"burst"` // 0 表示和 rate 相同
+	Wait        Duration `json:"wait"`
+}
+
+type TransportConfig struct {
+	TLS        TLSConfig `json:"tls"`
+	HMACSecret string    `json:"hmac_secret"` // 节点之间请求签名的共享密钥, 为空时不认证
+}
+
+// 节点之间的双向认证 TLS, 三个文件都为空时不启用
+type TLSConfig struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	CA   string `json:"ca"`
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.Cert != "" || c.Key != "" || c.CA != ""
+}
+
+type LogConfig struct {
+	File         string `json:"file"` // 为空时输出到 stderr
+	Microseconds bool   `json:"microseconds"`
+	UTC          bool   `json:"utc"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Listen: ListenConfig{API: ":9999"},
+	}
+}
+
+// 读取配置文件, 按扩展名识别 YAML、JSON 或 TOML。
+// 所有格式都先转换成 JSON 再解析, 字段名和类型检查只有一套
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		var m map[string]interface{}
+		_, err = toml.Decode(string(data), &m)
+		raw = m
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("%s: unknown config format %q, use .yaml, .json or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if raw == nil {
+		raw = map[string]interface{}{} // 空文件
+	}
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	config := defaultConfig()
+	decoder := json.NewDecoder(bytes.NewReader(normalized))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// 用 RUOCACHE_ 开头的环境变量覆盖配置, 变量名由字段路径组成, 例如 RUOCACHE_PEERS_MEMBERS。
+// 列表用逗号分隔, groups 不能通过环境变量修改。environ 的格式和 os.Environ 相同
+func (c *Config) applyEnv(environ []string) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, envPrefix) {
+			env[k] = v
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(c).Elem(), envPrefix, env); err != nil {
+		return err
+	}
+	// 剩下的变量没有对应的字段, 多半是拼错了
+	for k := range env {
+		return fmt.Errorf("%s: unknown config field", k)
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func applyEnv(v reflect.Value, prefix string, env map[string]string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		name := prefix + strings.ToUpper(v.Type().Field(i).Tag.Get("json"))
+		if field.Kind() == reflect.Struct && !field.Addr().Type().Implements(textUnmarshalerType) {
+			if err := applyEnv(field, name+"_", env); err != nil {
+				return err
+			}
+			continue
+		}
+		s, ok := env[name]
+		if !ok {
+			continue
+		}
+		delete(env, name)
+		if err := setField(field, s); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, s string) error {
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return errors.New("cannot be set from the environment")
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return errors.New("cannot be set from the environment")
+	}
+	return nil
+}
+
+// 检查配置, 返回所有问题而不是第一个
+func (c *Config) validate() error {
+	var problems []string
+	addf := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	l := c.Listen
+	if l.Peer == "" && l.API == "" && l.RESP == "" && l.Memcache == "" {
+		addf("listen: at least one address is required")
+	}
+	if c.MemoryBudget < 0 {
+		addf("memory_budget: must not be negative")
+	}
+
+	names := make(map[string]bool)
+	for i, g := range c.Groups {
+		switch {
+		case g.Name == "":
+			addf("groups[%d]: name is required", i)
+		case strings.Contains(g.Name, "/"):
+			addf("groups[%d]: name %q must not contain '/'", i, g.Name)
+		case strings.HasPrefix(g.Name, "_"):
+			addf("groups[%d]: name %q must not start with '_'", i, g.Name)
+		case names[g.Name]:
+			addf("groups[%d]: duplicate group %q", i, g.Name)
+		}
+		names[g.Name] = true
+		if g.CacheBytes <= 0 {
+			addf("groups[%d]: cache_bytes must be positive", i)
+		}
+		if g.HotCacheBytes < 0 || g.MinBytes < 0 || g.ChunkSize < 0 || g.TTL < 0 {
+			addf("groups[%d]: sizes and ttl must not be negative", i)
+		}
+		if (g.HotKey.Threshold > 0) != (g.HotKey.Lease > 0) {
+			addf("groups[%d]: hot_key threshold and lease must be set together", i)
+		}
+		if g.Load.Concurrency < 0 || g.Load.Rate < 0 || g.Load.Burst < 0 || g.Load.Wait < 0 {
+			addf("groups[%d]: load limits must not be negative", i)
+		}
+		if _, err := g.options(); err != nil {
+			addf("groups[%d]: %v", i, err)
+		}
+	}
+	if (l.RESP != "" || l.Memcache != "") && len(c.Groups) == 0 {
+		addf("listen: resp and memcache need at least one group")
+	}
+	if l.DefaultGroup != "" && !names[l.DefaultGroup] {
+		addf("listen.default_group: no such group %q", l.DefaultGroup)
+	}
+
+	p := c.Peers
+	if p.HandoffRate < 0 {
+		addf("peers.handoff_rate: must not be negative")
+	}
+	if strings.Contains(p.Name, ":") {
+		addf("peers.name: %q must not contain ':'", p.Name)
+	}
+	if _, ok := hashes[p.Hash]; !ok {
+		addf("peers.hash: unknown hash %q", p.Hash)
+	}
+	if p.Self != "" || len(p.Members) > 0 || l.Peer != "" {
+		if p.Self == "" || l.Peer == "" {
+			addf("peers: self and listen.peer are both required to join a cluster")
+		}
+		scheme := "http"
+		if c.Transport.TLS.enabled() {
+			scheme = "https"
+		}
+		self := false
+		for _, m := range p.Members {
+			u, err := url.Parse(m)
+			if err != nil || u.Scheme != scheme || u.Host == "" {
+				addf("peers.members: %q must be a %s:// URL", m, scheme)
+			}
+			self = self || m == p.Self
+		}
+		if p.Self != "" && !self {
+			addf("peers.members: must include self %q", p.Self)
+		}
+	}
+
+	t := c.Transport.TLS
+	if t.enabled() {
+		if t.Cert == "" || t.Key == "" || t.CA == "" {
+			addf("transport.tls: cert, key and ca are all required")
+		}
+		for _, f := range []string{t.Cert, t.Key, t.CA} {
+			if _, err := os.Stat(f); f != "" && err != nil {
+				addf("transport.tls: %v", err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// 分组的配置项, 不包括节点
+func (g GroupConfig) options() ([]ruoCache.GroupOption, error) {
+	opts := []ruoCache.GroupOption{
+		ruoCache.WithCacheBytes(int64(g.CacheBytes)),
+		ruoCache.WithTTL(time.Duration(g.TTL)),
+		ruoCache.WithMinBytes(int64(g.MinBytes)),
+	}
+	if g.HotCacheBytes != 0 {
+		opts = append(opts, ruoCache.WithHotCacheBytes(int64(g.HotCacheBytes)))
+	}
+	if g.ChunkSize != 0 {
+		opts = append(opts, ruoCache.WithChunkSize(int(g.ChunkSize)))
+	}
+	switch g.Compression {
+	case "":
+	case "flate":
+		opts = append(opts, ruoCache.WithCompression(ruoCache.Flate))
+	case "gzip":
+		opts = append(opts, ruoCache.WithCompression(ruoCache.Gzip))
+	default:
+		return nil, fmt.Errorf("unknown compression %q", g.Compression)
+	}
+	switch g.Eviction {
+	case "", "lru":
+	case "fifo":
+		opts = append(opts, ruoCache.WithEvictionPolicy(ruoCache.EvictFIFO))
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", g.Eviction)
+	}
+	switch {
+	case g.HotKey.Disabled:
+		opts = append(opts, ruoCache.WithHotKeyPolicy(0, 0))
+	case g.HotKey.Threshold > 0:
+		opts = append(opts, ruoCache.WithHotKeyPolicy(g.HotKey.Threshold, time.Duration(g.HotKey.Lease)))
+	}
+	if g.Load.Concurrency != 0 {
+		opts = append(opts, ruoCache.WithLoadConcurrency(g.Load.Concurrency))
+	}
+	if g.Load.Rate != 0 {
+		burst := g.Load.Burst
+		if burst == 0 {
+			burst = int(g.Load.Rate + 0.5)
+			if burst < 1 {
+				burst = 1
+			}
+		}
+		opts = append(opts, ruoCache.WithLoadRate(g.Load.Rate, burst))
+	}
+	if g.Load.Wait != 0 {
+		opts = append(opts, ruoCache.WithLoadWait(time.Duration(g.Load.Wait)))
+	}
+	return opts, nil
+}
+
+// peers.hash 可以使用的算法, "" 表示使用默认算法
+var hashes = map[string]consistentHash.Hash{
+	"":        nil,
+	"xxhash":  consistentHash.XXHash,
+	"fnv1a":   consistentHash.FNV1a,
+	"murmur3": consistentHash.Murmur3,
+	"crc32":   consistentHash.CRC32,
+}
+
+// 请求签名中的节点名称, 签名的格式用 ':' 分隔, 默认名称把 self 中的 ':' 换成 '-'
+func (p PeersConfig) nodeName() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	name := p.Self
+	if u, err := url.Parse(p.Self); err == nil && u.Host != "" {
+		name = u.Host
+	}
+	return strings.ReplaceAll(name, ":", "-")
+}
+
+// RESP 和 memcached 默认访问的分组
+func (c *Config) defaultGroup() string {
+	if c.Listen.DefaultGroup == "" && len(c.Groups) > 0 {
+		return c.Groups[0].Name
+	}
+	return c.Listen.DefaultGroup
+}
+
+// 生效的配置, 以 YAML 输出, 密钥会被隐藏
+func (c *Config) String() string {
+	redacted := *c
+	if redacted.Transport.HMACSecret != "" {
+		redacted.Transport.HMACSecret = "******"
+	}
+	b, _ := json.Marshal(redacted)
+	var m interface{}
+	json.Unmarshal(b, &m)
+	out, err := yaml.Marshal(m)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+// 字节数, 可以写成整数或者 "64MB"、"512KiB" 这样的字符串
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.ToUpper(strings.TrimSpace(string(text)))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", text)
+	}
+	if n > math.MaxInt64/unit || n < math.MinInt64/unit {
+		return fmt.Errorf("size %q is too large", text)
+	}
+	*b = ByteSize(n * unit)
+	return nil
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid size %s", data)
+	}
+	return b.UnmarshalText([]byte(s))
+}
+
+func (b ByteSize) MarshalJSON() ([]byte, error) {
+	n := int64(b)
+	for _, u := range byteUnits[3:6] {
+		if n != 0 && n%u.size == 0 {
+			return json.Marshal(fmt.Sprintf("%d%s", n/u.size, u.suffix))
+		}
+	}
+	return json.Marshal(n)
+}
+
+// 时间间隔, 写成 "30s" 这样的字符串
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s, use a string like \"30s\"", data)
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
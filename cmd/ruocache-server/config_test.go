@@ -0,0 +1,240 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 三种格式写出同样的配置, 解析结果应该相同
+func TestLoadConfigFormats(t *testing.T) {
+	files := map[string]string{
+		"c.yaml": `
+listen:
+  api: :8080
+  resp: :6379
+memory_budget: 64MB
+groups:
+  - name: scores
+    cache_bytes: 1024
+    ttl: 30s
+    hot_key: {threshold: 10, lease: 1s}
+`,
+		"c.json": `{
+  "listen": {"api": ":8080", "resp": ":6379"},
+  "memory_budget": "64MB",
+  "groups": [{"name": "scores", "cache_bytes": 1024, "ttl": "30s", "hot_key": {"threshold": 10, "lease": "1s"}}]
+}`,
+		"c.toml": `
+memory_budget = "64MB"
+[listen]
+api = ":8080"
+resp = ":6379"
+[[groups]]
+name = "scores"
+cache_bytes = 1024
+ttl = "30s"
+hot_key = { threshold = 10, lease = "1s" }
+`,
+	}
+	want := &Config{
+		Listen:       ListenConfig{API: ":8080", RESP: ":6379"},
+		MemoryBudget: 64 << 20,
+		Groups: []GroupConfig{{
+			Name:       "scores",
+			CacheBytes: 1024,
+			TTL:        Duration(30 * time.Second),
+			HotKey:     HotKeyConfig{Threshold: 10, Lease: Duration(time.Second)},
+		}},
+	}
+	for name, content := range files {
+		c, err := loadConfig(writeConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(c, want) {
+			t.Fatalf("%s: got %+v, want %+v", name, c, want)
+		}
+		if err := c.validate(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown.yaml": "listen:\n  apl: :8080\n",
+		"size.json":    `{"memory_budget": "lots"}`,
+		"ttl.json":     `{"groups": [{"name": "g", "cache_bytes": 1, "ttl": 30}]}`,
+		"c.ini":        "",
+	} {
+		if _, err := loadConfig(writeConfig(t, name, content)); err == nil {
+			t.Fatalf("%s: expect an error", name)
+		}
+	}
+	// 空文件使用默认配置
+	c, err := loadConfig(writeConfig(t, "empty.yaml", ""))
+	if err != nil || !reflect.DeepEqual(c, defaultConfig()) {
+		t.Fatalf("empty file: got %+v, %v", c, err)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	for s, want := range map[string]ByteSize{
+		"100":     100,
+		"512KiB":  512 << 10,
+		"64MB":    64 << 20,
+		"2 g":     2 << 30,
+		"10B":     10,
+		" 1gib  ": 1 << 30,
+	} {
+		var b ByteSize
+		if err := b.UnmarshalText([]byte(s)); err != nil || b != want {
+			t.Fatalf("%q: got %d, %v, want %d", s, b, err, want)
+		}
+	}
+	var b ByteSize
+	if err := b.UnmarshalText([]byte("1.5MB")); err == nil {
+		t.Fatalf("fractional sizes should be rejected")
+	}
+	for _, s := range []string{"9999999999G", "-9999999999G", "9223372036854775807K"} {
+		if err := b.UnmarshalText([]byte(s)); err == nil {
+			t.Fatalf("%q: overflowing sizes should be rejected, got %d", s, b)
+		}
+	}
+	for b, want := range map[ByteSize]string{0: "0", 1000: "1000", 2048: `"2KB"`, 3 << 30: `"3GB"`} {
+		got, _ := b.MarshalJSON()
+		if string(got) != want {
+			t.Fatalf("%d: got %s, want %s", b, got, want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	c := defaultConfig()
+	err := c.applyEnv([]string{
+		"HOME=/root",
+		"RUOCACHE_LISTEN_API=:8080",
+		"RUOCACHE_PEERS_MEMBERS=http://a:8001, http://b:8001",
+		"RUOCACHE_PEERS_HANDOFF_RATE=4MB",
+		"RUOCACHE_MEMORY_BUDGET=1GB",
+		"RUOCACHE_TRANSPORT_TLS_CA=/etc/ca.pem",
+		"RUOCACHE_LOG_UTC=true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen.API != ":8080" || c.MemoryBudget != 1<<30 || c.Peers.HandoffRate != 4<<20 ||
+		c.Transport.TLS.CA != "/etc/ca.pem" || !c.Log.UTC ||
+		!reflect.DeepEqual(c.Peers.Members, []string{"http://a:8001", "http://b:8001"}) {
+		t.Fatalf("unexpected config %+v", c)
+	}
+
+	for _, kv := range []string{
+		"RUOCACHE_LISTEN_APL=:8080",
+		"RUOCACHE_LOG_UTC=maybe",
+		"RUOCACHE_GROUPS=scores",
+	} {
+		if err := defaultConfig().applyEnv([]string{kv}); err == nil {
+			t.Fatalf("%s: expect an error", kv)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := &Config{
+		Listen: ListenConfig{Memcache: ":11211", DefaultGroup: "missing"},
+		Peers:  PeersConfig{Self: "http://a:8001", Name: "a:8001", Members: []string{"http://b:8001", "a:8001"}, Hash: "md5"},
+		Groups: []GroupConfig{
+			{Name: "g", CacheBytes: 1, Compression: "zstd"},
+			{Name: "g", HotKey: HotKeyConfig{Threshold: 10}},
+			{Name: "a/b", CacheBytes: 1, Load: LoadConfig{Rate: -1}},
+			{Name: "_hot", CacheBytes: 1, Eviction: "random"},
+		},
+		Transport: TransportConfig{TLS: TLSConfig{Cert: "/nonexistent/cert.pem"}},
+	}
+	err := c.validate()
+	if err == nil {
+		t.Fatalf("expect an error")
+	}
+	for _, want := range []string{
+		`groups[0]: unknown compression "zstd"`,
+		`groups[1]: duplicate group "g"`,
+		`groups[1]: cache_bytes must be positive`,
+		`groups[1]: hot_key threshold and lease must be set together`,
+		`groups[2]: name "a/b" must not contain '/'`,
+		`groups[2]: load limits must not be negative`,
+		`groups[3]: name "_hot" must not start with '_'`,
+		`groups[3]: unknown eviction policy "random"`,
+		`listen.default_group: no such group "missing"`,
+		`peers: self and listen.peer are both required`,
+		`peers.name: "a:8001" must not contain ':'`,
+		`peers.hash: unknown hash "md5"`,
+		`peers.members: "a:8001" must be a https:// URL`,
+		`peers.members: must include self "http://a:8001"`,
+		`transport.tls: cert, key and ca are all required`,
+		`transport.tls: stat /nonexistent/cert.pem`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing problem %q in:\n%v", want, err)
+		}
+	}
+
+	if err := (&Config{}).validate(); err == nil || !strings.Contains(err.Error(), "at least one address") {
+		t.Fatalf("expect a listen error, got %v", err)
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	c, err := loadConfig("ruocache.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range c.Groups {
+		if _, err := g.options(); err != nil {
+			t.Fatalf("group %s: %v", g.Name, err)
+		}
+	}
+	if c.defaultGroup() != "scores" || c.Groups[1].Load.Wait != Duration(200*time.Millisecond) ||
+		c.Groups[1].Eviction != "fifo" || c.Peers.nodeName() != "node-1" {
+		t.Fatalf("unexpected config %+v", c)
+	}
+}
+
+// 输出的配置隐藏密钥, 并且可以重新读取
+func TestConfigString(t *testing.T) {
+	c, err := loadConfig("ruocache.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := c.String()
+	if strings.Contains(out, "change-me") || !strings.Contains(out, "hmac_secret: '******'") {
+		t.Fatalf("secret should be redacted:\n%s", out)
+	}
+	if c.Transport.HMACSecret != "change-me" {
+		t.Fatalf("String should not modify the config")
+	}
+	reloaded, err := loadConfig(writeConfig(t, "effective.yaml", out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.Transport.HMACSecret = c.Transport.HMACSecret
+	if !reflect.DeepEqual(reloaded, c) {
+		t.Fatalf("round trip mismatch:\ngot  %+v\nwant %+v", reloaded, c)
+	}
+}
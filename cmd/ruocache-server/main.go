@@ -0,0 +1,219 @@
+// ruocache-server 是生产环境使用的缓存服务, 所有配置来自配置文件 (YAML、JSON 或 TOML) 和环境变量。
+// 启动时检查配置并输出生效的配置, 使用 -check 时只检查不启动。
+//
+//	ruocache-server -config /etc/ruocache.yaml
+//	RUOCACHE_LISTEN_API=:8080 ruocache-server -config /etc/ruocache.yaml -check
+//
+// 分组都在配置中定义, 服务没有数据源, 只保存通过 REST API、RESP 或 memcached 协议写入的值。
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"ruoCache"
+	"ruoCache/memcache"
+	"ruoCache/resp"
+	"ruoCache/restapi"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var path string
+	var check bool
+	flag.StringVar(&path, "config", "", "config file (.yaml, .json or .toml), empty to use defaults and the environment only")
+	flag.BoolVar(&check, "check", false, "validate and print the effective config, then exit")
+	flag.Parse()
+
+	config := defaultConfig()
+	if path != "" {
+		var err error
+		if config, err = loadConfig(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := config.applyEnv(os.Environ()); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if check {
+		fmt.Print(config)
+		return
+	}
+	if err := setupLog(config.Log); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("[RuoCache] effective config:\n%s", config)
+
+	s, err := newServer(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	errs := s.start()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		s.close()
+		log.Fatal(err)
+	case sig := <-signals:
+		log.Printf("[RuoCache] received %v, shutting down", sig)
+		s.close()
+	}
+}
+
+func setupLog(c LogConfig) error {
+	flags := log.LstdFlags
+	if c.Microseconds {
+		flags |= log.Lmicroseconds
+	}
+	if c.UTC {
+		flags |= log.LUTC
+	}
+	log.SetFlags(flags)
+	if c.File != "" {
+		f, err := os.OpenFile(c.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(f)
+	}
+	return nil
+}
+
+// 按配置创建的分组和各个服务
+type server struct {
+	config   *Config
+	registry *ruoCache.Registry
+	pool     *ruoCache.HttpPool // 单机运行时为空
+	api      *http.Server
+	resp     *resp.Server
+	memcache *memcache.Server
+}
+
+func newServer(c *Config) (*server, error) {
+	s := &server{config: c, registry: ruoCache.NewRegistry()}
+	s.registry.SetMemoryBudget(int64(c.MemoryBudget))
+
+	var picker ruoCache.PeerPicker
+	if c.Peers.Self != "" {
+		s.pool = ruoCache.NewHttpPoolWithRegistry(c.Peers.Self, s.registry)
+		if t := c.Transport.TLS; t.enabled() {
+			config, err := loadTLSConfig(t)
+			if err != nil {
+				return nil, err
+			}
+			s.pool.SetTLSConfig(config)
+		}
+		if secret := c.Transport.HMACSecret; secret != "" {
+			auth := &ruoCache.HMACAuth{Name: c.Peers.nodeName(), Secret: []byte(secret)}
+			s.pool.SetAuth(auth, nil)
+			s.pool.SetSigner(auth)
+		}
+		if c.Peers.HandoffRate > 0 {
+			if err := s.pool.SetHandoffRate(int64(c.Peers.HandoffRate)); err != nil {
+				return nil, err
+			}
+		}
+		s.pool.SetHash(hashes[c.Peers.Hash])
+		s.pool.Set(c.Peers.Members...)
+		picker = s.pool
+	}
+
+	for _, g := range c.Groups {
+		opts, err := g.options()
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %v", g.Name, err)
+		}
+		if picker != nil {
+			opts = append(opts, ruoCache.WithPeers(picker))
+		}
+		if _, err := s.registry.NewGroupWithOptions(g.Name, restapi.NoSource, opts...); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Listen.API != "" {
+		s.api = &http.Server{Addr: c.Listen.API, Handler: restapi.NewHandler(s.registry, picker)}
+	}
+	if c.Listen.RESP != "" {
+		s.resp = &resp.Server{Registry: s.registry, Group: c.defaultGroup()}
+	}
+	if c.Listen.Memcache != "" {
+		s.memcache = &memcache.Server{Registry: s.registry, Group: c.defaultGroup()}
+	}
+	return s, nil
+}
+
+// 启动所有服务, 任何一个服务退出时从返回的 channel 收到错误
+func (s *server) start() <-chan error {
+	errs := make(chan error, 4)
+	run := func(name, addr string, serve func() error) {
+		log.Printf("[RuoCache] %s is listening on %s", name, addr)
+		go func() {
+			errs <- fmt.Errorf("%s: %v", name, serve())
+		}()
+	}
+	l := s.config.Listen
+	if s.pool != nil {
+		run("peer server", l.Peer, func() error { return s.pool.ListenAndServe(l.Peer) })
+	}
+	if s.api != nil {
+		run("REST API", l.API, s.api.ListenAndServe)
+	}
+	if s.resp != nil {
+		run("RESP server", l.RESP, func() error { return s.resp.ListenAndServe(l.RESP) })
+	}
+	if s.memcache != nil {
+		run("memcache server", l.Memcache, func() error { return s.memcache.ListenAndServe(l.Memcache) })
+	}
+	return errs
+}
+
+// 停止接受请求并关闭所有分组, 写回模式的分组会写入剩余的数据
+func (s *server) close() {
+	if s.api != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		s.api.Shutdown(ctx)
+		cancel()
+	}
+	if s.resp != nil {
+		s.resp.Close()
+	}
+	if s.memcache != nil {
+		s.memcache.Close()
+	}
+	for _, g := range s.registry.Groups() {
+		if err := s.registry.DeleteGroup(g.Name()); err != nil {
+			log.Printf("[RuoCache] close group %s: %v", g.Name(), err)
+		}
+	}
+}
+
+// 节点之间的双向认证 TLS
+func loadTLSConfig(c TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := ioutil.ReadFile(c.CA)
+	if err != nil {
+		return nil, err
+	}
+	ca := x509.NewCertPool()
+	if !ca.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", c.CA)
+	}
+	return ruoCache.NewPeerTLSConfig(cert, ca), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 两个节点设置了 hmac_secret, 节点之间的请求签名后可以通过认证
+func TestClusterWithHMAC(t *testing.T) {
+	var servers [2]*server
+	var urls []string
+	for i := range servers {
+		i := i
+		peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			servers[i].pool.ServeHTTP(w, r)
+		}))
+		t.Cleanup(peer.Close)
+		urls = append(urls, peer.URL)
+	}
+	for i := range servers {
+		c := defaultConfig()
+		c.Listen.Peer = strings.TrimPrefix(urls[i], "http://")
+		c.Peers = PeersConfig{Self: urls[i], Members: urls}
+		c.Groups = []GroupConfig{{Name: "scores", CacheBytes: 1 << 20}}
+		c.Transport.HMACSecret = "cluster secret"
+		if err := c.validate(); err != nil {
+			t.Fatal(err)
+		}
+		if name := c.Peers.nodeName(); strings.Contains(name, ":") {
+			t.Fatalf("node name %q must not contain ':'", name)
+		}
+		s, err := newServer(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.close)
+		servers[i] = s
+	}
+
+	// 第二个节点负责的 key, 写入第一个节点后转发给第二个节点
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := servers[0].pool.PickPeer(strconv.Itoa(i)); ok {
+			key = strconv.Itoa(i)
+		}
+	}
+	do := func(s *server, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/groups/scores/keys/"+key, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.api.Handler.ServeHTTP(w, req)
+		return w
+	}
+	if w := do(servers[0], "PUT", `{"value":"630"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("signed write to the owner failed: %d %s", w.Code, w.Body)
+	}
+	for _, s := range servers {
+		if w := do(s, "GET", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"value":"630"`) {
+			t.Fatalf("unexpected response %d %s", w.Code, w.Body)
+		}
+	}
+
+	// 没有签名的请求被拒绝
+	res, err := http.Get(urls[1] + "/api/scores/" + key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expect unsigned requests to be rejected, got %d", res.StatusCode)
+	}
+}
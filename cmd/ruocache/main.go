@@ -0,0 +1,130 @@
+// ruocache 是独立运行的缓存服务, 通过 JSON 的 REST API 读写缓存, 分组需要先通过 API 或者 -groups 创建。
+// 服务没有数据源, 只保存写入的值。
+//
+// 指定 -self 和 -peers 时组成集群, 节点之间通过 -self 的地址通信, 读取、写入和删除会转发给 key 的负责节点。
+// 集群中不能通过 API 创建分组, 每个节点需要用 -groups 启动时创建相同的分组:
+//
+//	ruocache -addr :9999 -self http://localhost:8001 -peers http://localhost:8001,http://localhost:8002 -groups scores
+//
+// 指定 -tokens 时 API 需要 bearer token, 文件中每行是 "<token> <名称> <read|write|admin> [分组]",
+// 省略分组表示所有分组, 以 # 开头的行是注释:
+//
+//	s3cr3t  alice  read   scores
+//	t0ken   ops    admin
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"ruoCache"
+	"ruoCache/restapi"
+	"strings"
+)
+
+// -groups 创建的分组的缓存大小, 和 API 创建分组时的默认值相同
+const groupBytes = 64 << 20
+
+func main() {
+	var addr, self, peers, groups, tokens string
+	var memory int64
+	flag.StringVar(&addr, "addr", ":9999", "REST API listen address")
+	flag.StringVar(&self, "self", "", "URL other peers use to reach this node, empty to run standalone")
+	flag.StringVar(&peers, "peers", "", "comma separated peer URLs, including -self")
+	flag.StringVar(&groups, "groups", "", "comma separated groups to create at startup, required in a cluster")
+	flag.Int64Var(&memory, "memory", 0, "memory budget shared by all groups in bytes, 0 for no limit")
+	flag.StringVar(&tokens, "tokens", "", "file of client tokens and their permissions, empty to allow anyone")
+	flag.Parse()
+
+	registry := ruoCache.NewRegistry()
+	registry.SetMemoryBudget(memory)
+
+	var picker ruoCache.PeerPicker
+	if self != "" {
+		pool := ruoCache.NewHttpPoolWithRegistry(self, registry)
+		if peers != "" {
+			pool.Set(strings.Split(peers, ",")...)
+		}
+		u, err := url.Parse(self)
+		if err != nil {
+			log.Fatalf("invalid -self %q: %v", self, err)
+		}
+		go func() {
+			log.Println("ruoCache peer server is running at", self)
+			log.Fatal(pool.ListenAndServe(u.Host))
+		}()
+		picker = pool
+	}
+	if groups != "" {
+		for _, name := range strings.Split(groups, ",") {
+			opts := []ruoCache.GroupOption{ruoCache.WithCacheBytes(groupBytes)}
+			if picker != nil {
+				opts = append(opts, ruoCache.WithPeers(picker))
+			}
+			if _, err := registry.NewGroupWithOptions(strings.TrimSpace(name), restapi.NoSource, opts...); err != nil {
+				log.Fatalf("invalid -groups: %v", err)
+			}
+		}
+	}
+
+	handler := restapi.NewHandler(registry, picker)
+	if tokens != "" {
+		auth, acl, err := loadTokens(tokens)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler.SetAuth(auth, acl)
+	}
+
+	log.Println("ruoCache API server is running at", addr)
+	log.Fatal(http.ListenAndServe(addr, handler))
+}
+
+var permissions = map[string]ruoCache.Permission{
+	"read":  ruoCache.PermRead,
+	"write": ruoCache.PermWrite,
+	"admin": ruoCache.PermAdmin,
+}
+
+// 读取 -tokens 文件
+func loadTokens(path string) (ruoCache.BearerTokens, *ruoCache.ACL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	tokens := ruoCache.BearerTokens{}
+	acl := ruoCache.NewACL()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		perm, ok := ruoCache.Permission(0), false
+		if len(fields) == 3 || len(fields) == 4 {
+			perm, ok = permissions[fields[2]]
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("%s:%d: expect \"<token> <name> <read|write|admin> [group]\"", path, n)
+		}
+		if name, exists := tokens[fields[0]]; exists && name != fields[1] {
+			return nil, nil, fmt.Errorf("%s:%d: token is already used by %s", path, n, name)
+		}
+		group := "*"
+		if len(fields) == 4 {
+			group = fields[3]
+		}
+		tokens[fields[0]] = fields[1]
+		acl.Grant(fields[1], group, perm)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return tokens, acl, nil
+}
@@ -0,0 +1,144 @@
+// 分组可以配置一个压缩算法, 写入 mainCache 时压缩, 读取时才解压, lru 按压缩后的大小计算内存。
+// 压缩后没有变小的 value 保存原始数据。向其他节点查询时带上自己能解压的算法,
+// 对方命中了用同一个算法压缩的缓存时直接返回压缩后的数据。
+package ruoCache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	pb "ruoCache/ruoCachePb"
+	"sync"
+)
+
+// 压缩算法
+type Compressor interface {
+	// 节点之间协商时使用的名称
+	Name() string
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+var (
+	// 标准库的 DEFLATE
+	Flate Compressor = &flateCompressor{}
+	// 标准库的 gzip
+	Gzip Compressor = &gzipCompressor{}
+)
+
+type flateCompressor struct {
+	writers sync.Pool // 复用 *flate.Writer, 每次新建的开销很大
+}
+
+func (c *flateCompressor) Name() string {
+	return "flate"
+}
+
+func (c *flateCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := c.writers.Get().(*flate.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	}
+	defer c.writers.Put(w)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *flateCompressor) Decompress(b []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	return readDecompressed(r, maxEntrySize)
+}
+
+type gzipCompressor struct {
+	writers sync.Pool
+}
+
+func (c *gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (c *gzipCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := c.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	defer c.writers.Put(w)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCompressor) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readDecompressed(r, maxEntrySize)
+}
+
+// 读出解压后的数据, 最多 limit 字节, 避免很小的压缩数据解压后占满内存
+func readDecompressed(r io.Reader, limit int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("decompressed value too large: more than %d bytes", limit)
+	}
+	return b, nil
+}
+
+// 压缩后变小时保存压缩后的数据。超过 maxEntrySize 的 value 解压时会被拒绝, 因此不压缩
+func (g *Group) compress(e *cacheEntry) {
+	if g.compressor == nil || e.value.Len() > maxEntrySize {
+		return
+	}
+	b, err := g.compressor.Compress(e.value.contiguous())
+	if err != nil {
+		g.logger.Printf("[RuoCache] compress failed, store raw value: %v", err)
+		return
+	}
+	if len(b) >= e.value.Len() {
+		return
+	}
+	e.value = g.ownedView(b, e.value.version)
+	e.compressor = g.compressor
+}
+
+// 解压其他节点返回的数据
+func (g *Group) decompress(res *pb.Response) (ByteView, error) {
+	value := ByteView{b: res.GetValue(), version: res.GetVersion()}
+	name := res.GetCompression()
+	if name == "" {
+		return value, nil
+	}
+	if g.compressor == nil || g.compressor.Name() != name {
+		return ByteView{}, fmt.Errorf("unexpected compression: %s", name)
+	}
+	b, err := g.compressor.Decompress(value.b)
+	if err != nil {
+		return ByteView{}, fmt.Errorf("decompress response: %v", err)
+	}
+	value.b = b
+	return value, nil
+}
@@ -0,0 +1,108 @@
+package ruoCache
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	pb "ruoCache/ruoCachePb"
+	"strings"
+	"testing"
+)
+
+func TestCompressors(t *testing.T) {
+	raw := []byte(strings.Repeat(`{"name":"tom","score":630}`, 100))
+	for _, c := range []Compressor{Flate, Gzip} {
+		b, err := c.Compress(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) >= len(raw)/5 {
+			t.Errorf("%s: expect at least 5x smaller, got %d of %d", c.Name(), len(b), len(raw))
+		}
+		got, err := c.Decompress(b)
+		if err != nil || !bytes.Equal(got, raw) {
+			t.Fatalf("%s: round trip failed: %v", c.Name(), err)
+		}
+	}
+}
+
+func TestGroupCompression(t *testing.T) {
+	raw := strings.Repeat(`{"name":"tom","score":630}`, 100)
+	registry := NewRegistry()
+	g, err := registry.NewGroupWithOptions("compressed", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(raw), nil
+	}), WithCacheBytes(1<<20), WithCompression(Flate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := g.Watch(context.Background(), "tom")
+
+	if err := g.Set("tom", raw); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-events; !ev.Value.EqualString(raw) {
+		t.Fatalf("watchers should receive the decompressed value")
+	}
+	e, _ := g.mainCache.lookup("tom")
+	if e.compressor != Flate || e.value.Len() >= len(raw)/5 {
+		t.Fatalf("expect a compressed entry, got %d bytes", e.value.Len())
+	}
+	if b := g.mainCache.bytes(); b >= int64(len(raw)) {
+		t.Fatalf("lru should charge the compressed size, got %d", b)
+	}
+	if v, err := g.Get("tom"); err != nil || !v.EqualString(raw) {
+		t.Fatalf("Get should return the decompressed value")
+	}
+	if v, err := g.Get("jack"); err != nil || !v.EqualString(raw) {
+		t.Fatalf("loaded values should be decompressed on read")
+	}
+
+	g.Set("short", "x")
+	if e, _ := g.mainCache.lookup("short"); e.compressor != nil || !e.value.EqualString("x") {
+		t.Fatalf("values that do not shrink should be stored raw")
+	}
+
+	server := httptest.NewServer(NewHttpPoolWithRegistry("self", registry))
+	defer server.Close()
+	for _, accept := range []string{"", "flate", "gzip"} {
+		res, err := http.Get(server.URL + defaultBasePath + "compressed/tom?compression=" + accept)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		out := &pb.Response{}
+		if err := proto.Unmarshal(body, out); err != nil {
+			t.Fatal(err)
+		}
+		if accept == "flate" {
+			if out.GetCompression() != "flate" || len(out.GetValue()) >= len(raw)/5 {
+				t.Fatalf("expect a compressed response, got %q %d bytes", out.GetCompression(), len(out.GetValue()))
+			}
+			v, err := g.decompress(out)
+			if err != nil || !v.EqualString(raw) {
+				t.Fatalf("decompress response failed: %v", err)
+			}
+		} else if out.GetCompression() != "" || string(out.GetValue()) != raw {
+			t.Fatalf("accept %q: expect a raw response", accept)
+		}
+	}
+}
+
+// 很小的压缩数据解压后超过上限时返回错误, 而不是全部读入内存
+func TestDecompressLimit(t *testing.T) {
+	compressed, err := Flate.Compress(make([]byte, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readDecompressed(flate.NewReader(bytes.NewReader(compressed)), 1000); err != nil {
+		t.Fatalf("value of exactly the limit should be accepted: %v", err)
+	}
+	if _, err := readDecompressed(flate.NewReader(bytes.NewReader(compressed)), 999); err == nil {
+		t.Fatalf("expect an error when the decompressed value exceeds the limit")
+	}
+}
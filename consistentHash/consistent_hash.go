@@ -17,7 +17,6 @@
 package consistentHash
 
 import (
-	"hash/crc32"
 	"sort"
 	"strconv"
 )
@@ -25,36 +24,83 @@ import (
 // Hash maps bytes to uint32
 type Hash func(data []byte) uint32
 
+// 虚拟节点hash冲突时最多重新探测的次数
+const maxAttempts = 32
+
+// 虚拟节点
+type vnode struct {
+	key     string // 真实节点
+	replica int    // 第几个虚拟节点
+	attempt int    // hash冲突后重新探测的次数
+}
+
+// 虚拟节点的名称, 格式为 "<replica>#<key>", 冲突时为 "<replica>:<attempt>#<key>"。
+// '#' 之前只有数字和 ':', 因此不同节点的名称不会拼接出相同的字符串
+func (v vnode) label() string {
+	prefix := strconv.Itoa(v.replica)
+	if v.attempt > 0 {
+		prefix += ":" + strconv.Itoa(v.attempt)
+	}
+	return prefix + "#" + v.key
+}
+
 // map 存储所有的hash keys
 type Map struct {
 	hash     Hash
-	replicas int   // 虚拟节点倍数 ? 解决数据偏移问题而引入
-	keys     []int // Sorted
-	hashMap  map[int]string
+	replicas int             // 虚拟节点倍数 ? 解决数据偏移问题而引入
+	nodes    map[string]bool // 所有真实节点
+	keys     []int           // Sorted
+	hashMap  map[int]vnode
 }
 
-// 创建一个hash的实例
+// 创建一个hash的实例, fn 为空时使用 XXHash
 func New(replicas int, fn Hash) *Map {
 	m := &Map{
 		hash:     fn,
 		replicas: replicas,
-		hashMap:  make(map[int]string),
+		nodes:    make(map[string]bool),
+		hashMap:  make(map[int]vnode),
 	}
 	if m.hash == nil {
-		m.hash = crc32.ChecksumIEEE // 默认hash算法
+		m.hash = XXHash // 默认hash算法, crc32 对相似的虚拟节点名称分布很差
 	}
 	return m
 }
 
-// 添加
+// 添加节点, 重复添加同一个节点没有影响
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
+		m.nodes[key] = true
+	}
+	m.build()
+}
+
+// 按节点名称排序后重新放置所有虚拟节点, 这样同一组节点无论以什么顺序添加, 环都是一样的。
+// hash冲突时后放置的一方换一个名称重新计算hash, 直到找到空位;
+// 探测 maxAttempts 次仍然冲突的虚拟节点直接丢弃, 避免退化的hash函数导致死循环
+func (m *Map) build() {
+	nodes := make([]string, 0, len(m.nodes))
+	for key := range m.nodes {
+		nodes = append(nodes, key)
+	}
+	sort.Strings(nodes)
+
+	m.hashMap = make(map[int]vnode, len(nodes)*m.replicas)
+	for _, key := range nodes {
 		for i := 0; i < m.replicas; i++ {
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
+			for v := (vnode{key: key, replica: i}); v.attempt < maxAttempts; v.attempt++ {
+				hash := int(m.hash([]byte(v.label())))
+				if _, ok := m.hashMap[hash]; !ok {
+					m.hashMap[hash] = v
+					break
+				}
+			}
 		}
 	}
+	m.keys = m.keys[:0]
+	for hash := range m.hashMap {
+		m.keys = append(m.keys, hash)
+	}
 	sort.Ints(m.keys) // 排序
 }
 
@@ -70,5 +116,5 @@ func (m *Map) Get(key string) string {
 		return m.keys[i] >= hash
 	})
 	// 通过 hashMap 映射得到真实的节点。
-	return m.hashMap[m.keys[idx%len(m.keys)]]
+	return m.hashMap[m.keys[idx%len(m.keys)]].key
 }
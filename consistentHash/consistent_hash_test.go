@@ -0,0 +1,132 @@
+package consistentHash
+
+import (
+	"hash/crc32"
+	"strconv"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		if hash.Get(key) != hash.Get(key) {
+			t.Fatalf("key %s mapped to different nodes", key)
+		}
+	}
+	if New(50, nil).Get("key") != "" {
+		t.Fatalf("empty ring should return empty node")
+	}
+}
+
+func TestLabelsAreUnique(t *testing.T) {
+	labels := make(map[string]bool)
+	hash := New(20, func(data []byte) uint32 {
+		labels[string(data)] = true
+		return crc32.ChecksumIEEE(data)
+	})
+	// 旧的命名方式下 "1"+"2node" 和 "12"+"node" 会得到同一个虚拟节点
+	hash.Add("2node", "node")
+
+	if len(labels) != 40 || len(hash.keys) != 40 {
+		t.Fatalf("expect 40 distinct virtual nodes, got %d labels %d keys", len(labels), len(hash.keys))
+	}
+}
+
+func TestCollisionIsDeterministic(t *testing.T) {
+	// 只有 64 个槽位, 必然产生大量冲突
+	small := func(data []byte) uint32 {
+		return crc32.ChecksumIEEE(data) % 64
+	}
+	nodes := []string{"a", "b", "c", "d"}
+	m1 := New(8, small)
+	m1.Add(nodes...)
+	m2 := New(8, small)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		m2.Add(nodes[i])
+	}
+
+	if len(m1.keys) != 32 || len(m2.keys) != 32 {
+		t.Fatalf("collided virtual nodes were lost: %d, %d", len(m1.keys), len(m2.keys))
+	}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if m1.Get(key) != m2.Get(key) {
+			t.Fatalf("key %s: %s != %s, ring depends on insertion order", key, m1.Get(key), m2.Get(key))
+		}
+	}
+}
+
+func TestAddIsIdempotent(t *testing.T) {
+	small := func(data []byte) uint32 {
+		return crc32.ChecksumIEEE(data) % 64
+	}
+	m1 := New(8, small)
+	m1.Add("a", "b", "c", "d")
+	m2 := New(8, small)
+	m2.Add("d", "c")
+	m2.Add("a", "b", "c")
+	m2.Add("b", "d")
+
+	if len(m2.keys) != len(m1.keys) || len(m2.hashMap) != len(m1.hashMap) {
+		t.Fatalf("re-adding nodes changed the ring: %d keys, expect %d", len(m2.keys), len(m1.keys))
+	}
+	for hash, v := range m1.hashMap {
+		if m2.hashMap[hash] != v {
+			t.Fatalf("virtual node at %d: %v != %v", hash, m2.hashMap[hash], v)
+		}
+	}
+}
+
+func TestDegenerateHash(t *testing.T) {
+	hash := New(3, func(data []byte) uint32 { return 1 })
+	hash.Add("a", "b")
+	if hash.Get("key") != "a" {
+		t.Fatalf("expect the only virtual node to belong to a, got %s", hash.Get("key"))
+	}
+}
+
+func TestMurmur3(t *testing.T) {
+	cases := map[string]uint32{
+		"":      0,
+		"a":     0x3c2569b2,
+		"hello": 0x248bfa47,
+		"The quick brown fox jumps over the lazy dog": 0x2e4ff723,
+	}
+	for in, expect := range cases {
+		if got := Murmur3([]byte(in)); got != expect {
+			t.Errorf("Murmur3(%q) = %#x, expect %#x", in, got, expect)
+		}
+	}
+}
+
+// crc32 对只相差几个字符的虚拟节点名称分布很差 (三个节点时约 57%/18%/25%),
+// 所以默认使用 xxhash, 这里只检查新增的几种hash算法
+func TestDistribution(t *testing.T) {
+	hashes := map[string]Hash{
+		"default": nil,
+		"fnv1a":   FNV1a,
+		"xxhash":  XXHash,
+		"murmur3": Murmur3,
+	}
+	nodes := []string{"http://localhost:8001", "http://localhost:8002", "http://localhost:8003"}
+	const total = 30000
+
+	for name, fn := range hashes {
+		hash := New(50, fn)
+		hash.Add(nodes...)
+		counts := make(map[string]int)
+		for i := 0; i < total; i++ {
+			counts[hash.Get("key"+strconv.Itoa(i))]++
+		}
+		for _, node := range nodes {
+			share := float64(counts[node]) / total
+			t.Logf("%s %s %.3f", name, node, share)
+			if share < 0.2 || share > 0.47 {
+				t.Errorf("%s: node %s owns %.3f of keys", name, node, share)
+			}
+		}
+	}
+}
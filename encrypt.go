@@ -0,0 +1,182 @@
+// 分组可以配置 AES-GCM 加密, 写入 lru 之前 (压缩之后) 加密, 读取时才解密。
+// 密文带有加密时使用的密钥 ID, 轮换密钥后旧的缓存仍然可以用旧密钥解密, 新写入的缓存使用新密钥。
+// 附加数据包含分组名称和 key, 密文不能被挪到别的 key 下使用。
+// 节点之间的传输不在这里加密, 需要使用 TLS。
+package ruoCache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// 提供加密使用的密钥
+type KeyProvider interface {
+	// 当前用来加密的密钥和它的 ID
+	CurrentKey() (id string, key []byte, err error)
+	// 根据 ID 返回密钥, 用来解密之前写入的缓存
+	Key(id string) ([]byte, error)
+}
+
+// 保存在内存中的一组密钥
+type KeyRing struct {
+	mutex   sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// 新建一个 KeyRing, key 的长度必须是 16、24 或 32 字节
+func NewKeyRing(id string, key []byte) (*KeyRing, error) {
+	r := &KeyRing{keys: make(map[string][]byte)}
+	if err := r.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 添加一个密钥并用它加密之后写入的缓存
+func (r *KeyRing) Rotate(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.keys[id] = cloneBytes(key)
+	r.current = id
+	return nil
+}
+
+// 删除不再使用的密钥, 用它加密的缓存之后会解密失败。不能删除当前的密钥
+func (r *KeyRing) Retire(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if id == r.current {
+		return errors.New("cannot retire the current key")
+	}
+	delete(r.keys, id)
+	return nil
+}
+
+func (r *KeyRing) CurrentKey() (string, []byte, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.current, r.keys[r.current], nil
+}
+
+func (r *KeyRing) Key(id string) ([]byte, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", id)
+	}
+	return key, nil
+}
+
+var _ KeyProvider = (*KeyRing)(nil)
+
+// 分组的加解密, 密文的格式为: ID 长度 (1 字节) | ID | nonce | 密文
+type envelope struct {
+	group string
+	keys  KeyProvider
+
+	mutex sync.Mutex
+	aeads map[string]cachedAEAD // 按密钥 ID 缓存
+}
+
+// 缓存的 AEAD 和生成它的密钥, 同一个 ID 下的密钥被替换后重新生成
+type cachedAEAD struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+func newEnvelope(group string, keys KeyProvider) *envelope {
+	return &envelope{group: group, keys: keys, aeads: make(map[string]cachedAEAD)}
+}
+
+func (s *envelope) aead(id string, key []byte) (cipher.AEAD, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c, ok := s.aeads[id]; ok && subtle.ConstantTimeCompare(c.key, key) == 1 {
+		return c.aead, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s.aeads[id] = cachedAEAD{key: cloneBytes(key), aead: aead}
+	return aead, nil
+}
+
+func (s *envelope) additionalData(key string) []byte {
+	return []byte(s.group + "\x00" + key)
+}
+
+func (s *envelope) seal(key string, plain []byte) ([]byte, error) {
+	id, k, err := s.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if id == "" || len(id) > 255 {
+		return nil, fmt.Errorf("invalid key id %q", id)
+	}
+	aead, err := s.aead(id, k)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, 1+len(id)+aead.NonceSize()+len(plain)+aead.Overhead())
+	out = append(out, byte(len(id)))
+	out = append(out, id...)
+	nonce := out[len(out) : len(out)+aead.NonceSize()]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = out[:len(out)+len(nonce)]
+	return aead.Seal(out, nonce, plain, s.additionalData(key)), nil
+}
+
+func (s *envelope) open(key string, sealed []byte) ([]byte, error) {
+	if len(sealed) < 1 || len(sealed) < 1+int(sealed[0]) {
+		return nil, errors.New("malformed ciphertext")
+	}
+	id := string(sealed[1 : 1+sealed[0]])
+	sealed = sealed[1+len(id):]
+	k, err := s.keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(id, k)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed ciphertext")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, s.additionalData(key))
+}
+
+// 加密缓存, 失败时不能保存明文
+func (g *Group) encrypt(key string, e *cacheEntry) error {
+	if g.envelope == nil {
+		return nil
+	}
+	b, err := g.envelope.seal(key, e.value.contiguous())
+	if err != nil {
+		return fmt.Errorf("encrypt %s: %v", key, err)
+	}
+	e.value = g.ownedView(b, e.value.version)
+	e.envelope = g.envelope
+	return nil
+}
@@ -0,0 +1,134 @@
+package ruoCache
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	pb "ruoCache/ruoCachePb"
+	"strings"
+	"testing"
+)
+
+func TestKeyRing(t *testing.T) {
+	if _, err := NewKeyRing("k1", []byte("short")); err == nil {
+		t.Fatalf("expect an error for an invalid key size")
+	}
+	if _, err := NewKeyRing("", bytes.Repeat([]byte{1}, 32)); err == nil {
+		t.Fatalf("expect an error for an empty key id")
+	}
+	r, err := NewKeyRing("k1", bytes.Repeat([]byte{1}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Retire("k1"); err == nil {
+		t.Fatalf("expect an error when retiring the current key")
+	}
+	r.Rotate("k2", bytes.Repeat([]byte{2}, 32))
+	if id, _, _ := r.CurrentKey(); id != "k2" {
+		t.Fatalf("expect k2 to be the current key, got %s", id)
+	}
+	if _, err := r.Key("k1"); err != nil {
+		t.Fatalf("old keys should still be available: %v", err)
+	}
+}
+
+func TestGroupEncryption(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	secret := strings.Repeat("ssn:123-45-6789;", 20)
+	keys, _ := NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+	loads := 0
+	g, err := buildGroup("pii", GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(secret), nil
+	}), WithCacheBytes(1<<20), WithCompression(Flate), WithEncryption(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.Set("tom", secret); err != nil {
+		t.Fatal(err)
+	}
+	e, _ := g.mainCache.lookup("tom")
+	if e.envelope == nil || strings.Contains(e.value.String(), "ssn") {
+		t.Fatalf("cached value should be encrypted")
+	}
+	if v, err := g.Get("tom"); err != nil || !v.EqualString(secret) {
+		t.Fatalf("Get should return the plaintext, got %v", err)
+	}
+	if _, err := g.envelope.open("jack", e.value.ByteSlice()); err == nil {
+		t.Fatalf("ciphertext should be bound to its key")
+	}
+
+	// 轮换之后旧的缓存仍然可以解密, 新的缓存使用新密钥
+	keys.Rotate("k2", bytes.Repeat([]byte{2}, 32))
+	g.Set("jack", secret)
+	if e, _ := g.mainCache.lookup("jack"); string(e.value.ByteSlice()[1:3]) != "k2" {
+		t.Fatalf("new entries should use the current key")
+	}
+	if v, err := g.Get("tom"); err != nil || !v.EqualString(secret) {
+		t.Fatalf("entries sealed with an old key should still decrypt, got %v", err)
+	}
+
+	// 删除旧密钥后, 用它加密的缓存重新加载
+	keys.Retire("k1")
+	if v, err := g.Get("tom"); err != nil || !v.EqualString(secret) || loads != 1 {
+		t.Fatalf("expect a reload after the key was retired, loads=%d err=%v", loads, err)
+	}
+
+	if strings.Contains(logs.String(), "ssn") {
+		t.Fatalf("logs must not contain plaintext values")
+	}
+}
+
+// 热点 key 的副本在 hotCache 中同样是加密的
+func TestReplicaEncryption(t *testing.T) {
+	secret := strings.Repeat("ssn:123-45-6789;", 20)
+	keys, _ := NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+	registry := NewRegistry()
+	g, err := registry.NewGroupWithOptions("pii", GetterFunc(func(key string) ([]byte, error) {
+		return nil, nil
+	}), WithCacheBytes(1<<20), WithEncryption(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewHttpPoolWithRegistry("", registry)
+	server := httptest.NewServer(pool)
+	defer server.Close()
+
+	peer := &httpGetter{baseURL: server.URL + defaultBasePath, client: http.DefaultClient}
+	err = peer.Replicate(&pb.Replica{Group: "pii", Key: "tom", Value: []byte(secret), Version: 1, LeaseMs: 60000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := g.hotCache.lookup("tom")
+	if !ok || e.envelope == nil || strings.Contains(e.value.String(), "ssn") {
+		t.Fatalf("replica should be encrypted")
+	}
+	if v, ok := g.hotCache.get("tom"); !ok || !v.EqualString(secret) {
+		t.Fatalf("replica should decrypt to the plaintext")
+	}
+}
+
+// 同一个 ID 下的密钥被替换后使用新的密钥
+func TestKeyReplacedUnderSameID(t *testing.T) {
+	keys, _ := NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+	s := newEnvelope("g", keys)
+	if _, err := s.seal("tom", []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	keys.Rotate("k1", bytes.Repeat([]byte{2}, 32))
+	sealed, err := s.seal("tom", []byte("630"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, _ := NewKeyRing("k1", bytes.Repeat([]byte{2}, 32))
+	if plain, err := newEnvelope("g", fresh).open("tom", sealed); err != nil || string(plain) != "630" {
+		t.Fatalf("value should be sealed with the replaced key, got %q %v", plain, err)
+	}
+}
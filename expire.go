@@ -0,0 +1,63 @@
+// 查询和修改单个 key 的过期时间, 只作用于本节点已经缓存的 key, 不会触发加载。
+package ruoCache
+
+import "time"
+
+// 修改缓存的过期时间, 零值表示永不过期。key 不存在时返回 false
+func (c *cache) setExpire(key string, expire time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	e, ok := c.peek(key)
+	if !ok {
+		return false
+	}
+	updated := *e // 缓存中的条目不能修改, 替换成新的条目
+	updated.expire = expire
+	c.lru.Add(key, &updated)
+	return true
+}
+
+// key 是否已经缓存在本节点上
+func (g *Group) Exists(key string) bool {
+	if _, ok := g.mainCache.lookup(key); ok {
+		return true
+	}
+	_, ok := g.hotCache.lookup(key)
+	return ok
+}
+
+// 返回缓存的剩余时间, 永不过期时返回 -1。key 没有缓存在本节点上时 ok 为 false
+func (g *Group) TTL(key string) (ttl time.Duration, ok bool) {
+	e, ok := g.mainCache.lookup(key)
+	if !ok {
+		if e, ok = g.hotCache.lookup(key); !ok {
+			return 0, false
+		}
+	}
+	if e.expire.IsZero() {
+		return -1, true
+	}
+	return time.Until(e.expire), true
+}
+
+// 设置 mainCache 中缓存的剩余时间, ttl 不大于 0 时和 Delete 一样同时删除热点副本。
+// key 没有缓存在本节点上时返回 false
+func (g *Group) Expire(key string, ttl time.Duration) bool {
+	if ttl <= 0 {
+		if !g.Exists(key) {
+			return false
+		}
+		g.mainCache.remove(key)
+		g.hotCache.remove(key)
+		return true
+	}
+	return g.mainCache.setExpire(key, time.Now().Add(ttl))
+}
+
+// 取消 mainCache 中缓存的过期时间。key 没有缓存在本节点上时返回 false
+func (g *Group) Persist(key string) bool {
+	return g.mainCache.setExpire(key, time.Time{})
+}
@@ -0,0 +1,52 @@
+package ruoCache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	g := newGroup("expire", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	if g.Exists("tom") {
+		t.Fatalf("tom should not be cached before loading")
+	}
+	if _, ok := g.TTL("tom"); ok {
+		t.Fatalf("TTL of an uncached key should not be ok")
+	}
+	if g.Expire("tom", time.Minute) {
+		t.Fatalf("Expire of an uncached key should return false")
+	}
+
+	g.Get("tom")
+	if ttl, ok := g.TTL("tom"); !ok || ttl != -1 {
+		t.Fatalf("expect no expiry, got %v %v", ttl, ok)
+	}
+	if !g.Expire("tom", time.Minute) {
+		t.Fatalf("Expire of a cached key should return true")
+	}
+	if ttl, _ := g.TTL("tom"); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+
+	g.Expire("tom", 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	if g.Exists("tom") {
+		t.Fatalf("tom should be expired")
+	}
+
+	g.Get("tom")
+	if !g.Expire("tom", 0) || g.Exists("tom") {
+		t.Fatalf("Expire with a non-positive ttl should remove the key")
+	}
+
+	// 热点副本也要删除, 否则之后的 Get 仍然返回旧值
+	h := newGroup("expire-hot", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	h.hotCache.add("jack", ByteView{b: []byte("589")})
+	if !h.Expire("jack", 0) || h.Exists("jack") {
+		t.Fatalf("Expire with a non-positive ttl should remove the hot replica")
+	}
+}
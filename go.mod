@@ -1,10 +1,13 @@
 module ruoCache
 
-go 1.15
+go 1.18
 
 require (
+	github.com/BurntSushi/toml v0.3.0
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/golang/protobuf v1.4.3
 	google.golang.org/protobuf v1.23.0
+	gopkg.in/yaml.v3 v3.0.1
 	ruocache v0.0.0
 )
 
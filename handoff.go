@@ -0,0 +1,219 @@
+// 节点加入后, 它负责的 key 仍然缓存在原来的节点上, 新节点只能重新从数据源加载。
+// 为了避免这种情况, 节点变化时每个节点找出 mainCache 中已经不归自己负责的 key,
+// 通过批量传输接口按限速发送给新的负责节点, 发送成功后从本地删除。
+package ruoCache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"ruoCache/consistentHash"
+	pb "ruoCache/ruoCachePb"
+	"time"
+)
+
+const (
+	handoffPath        = "_handoff"                         // /<basepath>/_handoff/<groupname>
+	defaultHandoffRate = 4 << 20                            // 默认每秒 4MB
+	maxEntrySize       = 64 << 20                           // 单个条目的最大字节数
+	maxResponseSize    = maxEntrySize + 64<<10              // 单个条目加上 protobuf 的其他字段
+	handoffBatchSize   = 4 << 20                            // 迁移时每个请求的大小
+	maxRequestSize     = handoffBatchSize + maxResponseSize // 一批迁移的缓存, 最后一个条目可能超出批次大小
+)
+
+// 迁移被之后的节点变化取消
+var errHandoffCanceled = errors.New("handoff canceled")
+
+// 把不再属于自己的缓存发送给新的负责节点, stop 关闭后尽快停止
+func (p *HttpPool) handoff(ring *consistentHash.Map, getters map[string]*httpGetter, stop <-chan struct{}) {
+	p.mutex.Lock()
+	rate := float64(p.handoffRate)
+	p.mutex.Unlock()
+	limiter := newTokenBucket(rate, rate)
+
+	for _, g := range p.registry.Groups() {
+		moved := make(map[string]map[string]*cacheEntry)
+		items := g.mainCache.items(func(key string) bool {
+			owner := ring.Get(key)
+			return owner != "" && owner != p.self
+		})
+		for key, value := range items {
+			owner := ring.Get(key)
+			if moved[owner] == nil {
+				moved[owner] = make(map[string]*cacheEntry)
+			}
+			moved[owner][key] = value
+		}
+
+		for owner, entries := range moved {
+			n, err := getters[owner].handoff(g.name, entries, limiter, stop)
+			if err == errHandoffCanceled {
+				p.Log("handoff canceled by a newer peer set")
+				return
+			}
+			if err != nil {
+				p.Log("handoff group %s to %s failed: %v", g.name, owner, err)
+				continue
+			}
+			// 迁移期间被重新写入的 key 保留在本地
+			for key, e := range entries {
+				g.mainCache.removeIfVersion(key, e.value.version)
+			}
+			p.Log("handoff %d keys of group %s to %s", n, g.name, owner)
+		}
+	}
+}
+
+// 接收其他节点迁移过来的缓存, 版本号不比本地新的会被丢弃
+func (p *HttpPool) serveHandoff(w http.ResponseWriter, r *http.Request, groupName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := p.registry.GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+
+	var received int64
+	reader := bufio.NewReader(r.Body)
+	for {
+		entry := &pb.Entry{}
+		err := readDelimited(reader, entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		group.observeVersion(entry.GetVersion())
+		value := ByteView{b: entry.GetValue(), version: entry.GetVersion()}
+		e, err := group.newEntry(entry.GetKey(), value, entry.GetTags())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if group.mainCache.addIfNewer(entry.GetKey(), e) {
+			received++
+		}
+	}
+
+	body, err := proto.Marshal(&pb.HandoffResponse{Received: received})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// 分批发送缓存, 每批作为一个请求整体签名, 返回对方实际保存的条数
+func (h *httpGetter) handoff(group string, entries map[string]*cacheEntry, limiter *tokenBucket, stop <-chan struct{}) (int64, error) {
+	var received int64
+	var batch bytes.Buffer
+	for key, e := range entries {
+		select {
+		case <-stop:
+			return received, errHandoffCanceled
+		default:
+		}
+		value, err := e.view(key)
+		if err != nil {
+			log.Printf("[RuoCache] skip handoff of %s: %v", key, err)
+			continue
+		}
+		body, err := proto.Marshal(&pb.Entry{
+			Key:     key,
+			Value:   value.contiguous(),
+			Version: e.value.version,
+			Tags:    e.tags,
+		})
+		if err != nil {
+			return received, err
+		}
+		if d := limiter.reserve(float64(len(body))); d > 0 {
+			timer := time.NewTimer(d)
+			select {
+			case <-stop:
+				timer.Stop()
+				return received, errHandoffCanceled
+			case <-timer.C:
+			}
+		}
+		writeDelimited(&batch, body)
+		if batch.Len() < handoffBatchSize {
+			continue
+		}
+		n, err := h.postHandoff(group, batch.Bytes())
+		received += n
+		if err != nil {
+			return received, err
+		}
+		batch.Reset()
+	}
+	if batch.Len() > 0 {
+		n, err := h.postHandoff(group, batch.Bytes())
+		return received + n, err
+	}
+	return received, nil
+}
+
+// 发送一批缓存
+func (h *httpGetter) postHandoff(group string, batch []byte) (int64, error) {
+	u := fmt.Sprintf("%v%v/%v", h.baseURL, handoffPath, url.PathEscape(group))
+	res, err := h.client.Post(u, "application/octet-stream", bytes.NewReader(batch))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned: %v", res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, fmt.Errorf("reading response body: %v", err)
+	}
+	out := &pb.HandoffResponse{}
+	if err = proto.Unmarshal(body, out); err != nil {
+		return 0, fmt.Errorf("decoding response body: %v", err)
+	}
+	return out.GetReceived(), nil
+}
+
+// 写入一条带长度前缀的消息
+func writeDelimited(w io.Writer, body []byte) error {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(body)))
+	if _, err := w.Write(size[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// 读取一条带长度前缀的消息, 没有更多消息时返回 io.EOF
+func readDelimited(r *bufio.Reader, m proto.Message) error {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if size > maxEntrySize {
+		return fmt.Errorf("entry too large: %d bytes", size)
+	}
+	body := make([]byte, size)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return err
+	}
+	return proto.Unmarshal(body, m)
+}
@@ -0,0 +1,214 @@
+package ruoCache
+
+import (
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	pb "ruoCache/ruoCachePb"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 三个节点的测试集群, 每个节点都有自己的 scores 分组
+type testNode struct {
+	server *httptest.Server
+	pool   *HttpPool
+	group  *Group
+	mutex  sync.Mutex
+	loads  map[string]int
+}
+
+func newTestNode(t *testing.T) *testNode {
+	n := &testNode{loads: make(map[string]int)}
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.pool.ServeHTTP(w, r)
+	}))
+	t.Cleanup(n.server.Close)
+
+	registry := NewRegistry()
+	n.group = registry.NewGroup("scores", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+		n.loads[key]++
+		return []byte("value of " + key), nil
+	}))
+	n.pool = NewHttpPoolWithRegistry(n.server.URL, registry)
+	n.group.RegisterPeers(n.pool)
+	return n
+}
+
+func (n *testNode) loadCount(key string) int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.loads[key]
+}
+
+func TestHandoff(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t), newTestNode(t)}
+	urls := []string{nodes[0].server.URL, nodes[1].server.URL, nodes[2].server.URL}
+
+	// 一开始只有前两个节点
+	nodes[0].pool.Set(urls[:2]...)
+	nodes[1].pool.Set(urls[:2]...)
+	nodes[2].pool.Set(urls...)
+
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		if _, err := nodes[0].group.Get(keys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 第三个节点加入
+	nodes[0].pool.Set(urls...)
+	nodes[1].pool.Set(urls...)
+	nodes[0].pool.handoffs.Wait()
+	nodes[1].pool.handoffs.Wait()
+
+	moved := 0
+	for _, key := range keys {
+		if nodes[2].pool.peers.Get(key) != urls[2] {
+			continue
+		}
+		moved++
+		if _, ok := nodes[2].group.mainCache.get(key); !ok {
+			t.Fatalf("key %s was not handed off to the new owner", key)
+		}
+		for _, n := range nodes[:2] {
+			if _, ok := n.group.mainCache.get(key); ok {
+				t.Fatalf("key %s is still cached on its old owner", key)
+			}
+		}
+		if view, err := nodes[0].group.Get(key); err != nil || view.String() != "value of "+key {
+			t.Fatalf("failed to get %s after handoff", key)
+		}
+		if nodes[2].loadCount(key) != 0 {
+			t.Fatalf("new owner loaded %s from the getter", key)
+		}
+	}
+	if moved == 0 {
+		t.Fatalf("no key moved to the new node")
+	}
+}
+
+func TestHandoffRejectsStaleEntries(t *testing.T) {
+	n := newTestNode(t)
+	n.group.Set("tom", "new")
+	current, _ := n.group.mainCache.get("tom")
+
+	var body bytes.Buffer
+	for _, e := range []*pb.Entry{
+		{Key: "tom", Value: []byte("old"), Version: current.Version() - 1},
+		{Key: "jack", Value: []byte("589"), Version: 1},
+	} {
+		b, _ := proto.Marshal(e)
+		writeDelimited(&body, b)
+	}
+	res, err := http.Post(n.server.URL+defaultBasePath+handoffPath+"/scores", "application/octet-stream", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if v, _ := n.group.mainCache.get("tom"); v.String() != "new" {
+		t.Fatalf("stale handoff overwrote tom with %s", v)
+	}
+	if v, _ := n.group.mainCache.get("jack"); v.String() != "589" {
+		t.Fatalf("handoff of jack was lost")
+	}
+}
+
+func TestSetHandoffRate(t *testing.T) {
+	pool := NewHttpPoolWithRegistry("self", NewRegistry())
+	for _, rate := range []int64{0, -1} {
+		if err := pool.SetHandoffRate(rate); err == nil {
+			t.Fatalf("rate %d should be rejected", rate)
+		}
+	}
+	if err := pool.SetHandoffRate(1 << 20); err != nil || pool.handoffRate != 1<<20 {
+		t.Fatalf("failed to set handoff rate: %v", err)
+	}
+}
+
+// 迁移的缓存分批发送, 每个请求都可以整体签名
+func TestHandoffBatches(t *testing.T) {
+	n := newTestNode(t)
+	auth := &HMACAuth{Name: "peer", Secret: []byte("cluster secret")}
+	n.pool.SetAuth(auth, nil)
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		n.pool.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	entries := make(map[string]*cacheEntry)
+	for i := 0; i < 3; i++ {
+		value := bytes.Repeat([]byte{byte(i)}, handoffBatchSize/2)
+		entries["key"+strconv.Itoa(i)] = &cacheEntry{value: ByteView{b: value, version: 1}}
+	}
+	getter := &httpGetter{
+		baseURL: server.URL + defaultBasePath,
+		client:  &http.Client{Transport: &signingTransport{base: http.DefaultTransport, signer: auth}},
+	}
+	received, err := getter.handoff("scores", entries, newTokenBucket(1<<30, 1<<30), nil)
+	if err != nil || received != 3 {
+		t.Fatalf("expect 3 entries to be received, got %d %v", received, err)
+	}
+	if requests != 2 {
+		t.Fatalf("expect 2 batches, got %d", requests)
+	}
+}
+
+func TestHandoffCanceled(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t)}
+	urls := []string{nodes[0].server.URL, nodes[1].server.URL}
+	nodes[0].pool.Set(urls[0])
+	for i := 0; i < 100; i++ {
+		if _, err := nodes[0].group.Get("key" + strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 每秒 1 字节, 第一次迁移要等很久才能发送第一个条目
+	if err := nodes[0].pool.SetHandoffRate(1); err != nil {
+		t.Fatal(err)
+	}
+	nodes[0].pool.Set(urls...)
+	nodes[0].pool.Set(urls[0])
+	done := make(chan struct{})
+	go func() {
+		nodes[0].pool.handoffs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the first handoff was not canceled by the newer peer set")
+	}
+	if n := nodes[0].group.mainCache.lru.Len(); n != 100 {
+		t.Fatalf("canceled handoff should keep all keys, %d left", n)
+	}
+}
+
+func TestPeerKeyEscaping(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t)}
+	urls := []string{nodes[0].server.URL, nodes[1].server.URL}
+	for _, n := range nodes {
+		n.pool.Set(urls...)
+	}
+	for i := 0; i < 20; i++ {
+		key := "a b+c%" + strconv.Itoa(i)
+		v, err := nodes[0].group.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.String() != "value of "+key {
+			t.Fatalf("peer loaded the wrong key: %q", v)
+		}
+	}
+}
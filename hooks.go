@@ -0,0 +1,56 @@
+// 分组的日志和监控。日志默认写入标准库的 log, 可以通过 WithLogger 替换或者关闭;
+// WithStatsHooks 注册的回调在命中、未命中和加载时同步调用, 用来接入 Prometheus 等监控系统。
+package ruoCache
+
+import (
+	"log"
+	"time"
+)
+
+// 分组使用的日志, *log.Logger 实现了这个接口
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// 分组内部事件的回调, 为空的回调不会被调用。
+// 回调在处理请求的协程中同步调用, 不能阻塞
+type StatsHooks struct {
+	// 命中本节点的缓存, hot 表示命中的是其他节点复制过来的热点副本
+	OnHit func(group string, hot bool)
+	// 本节点没有缓存, 需要从其他节点或者数据源加载
+	OnMiss func(group string)
+	// 一次加载结束, 并发的相同请求只加载一次。fromPeer 表示从其他节点加载,
+	// 从其他节点加载失败后会再从数据源加载一次
+	OnLoad func(group string, fromPeer bool, d time.Duration, err error)
+}
+
+func (h StatsHooks) empty() bool {
+	return h.OnHit == nil && h.OnMiss == nil && h.OnLoad == nil
+}
+
+func (g *Group) recordHit(hot bool) {
+	if g.stats.OnHit != nil {
+		g.stats.OnHit(g.name, hot)
+	}
+}
+
+func (g *Group) recordMiss() {
+	if g.stats.OnMiss != nil {
+		g.stats.OnMiss(g.name)
+	}
+}
+
+func (g *Group) recordLoad(fromPeer bool, start time.Time, err error) {
+	if g.stats.OnLoad != nil {
+		g.stats.OnLoad(g.name, fromPeer, time.Since(start), err)
+	}
+}
+
+// 写入 logger, 为空时使用标准库的 log
+func logf(logger Logger, format string, v ...interface{}) {
+	if logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	logger.Printf(format, v...)
+}
@@ -0,0 +1,286 @@
+// 少数热点 key 的请求全部落在同一个节点上, 会把这个节点的 CPU 打满。
+// 每个分组用 count-min sketch 统计 key 的访问次数, 并定期衰减, 这样统计的是最近的热度。
+// 负责某个 key 的节点发现它超过阈值后, 通知其他节点在租期内把它保存在 hotCache 中,
+// 之后其他节点可以直接返回, 不需要再请求负责的节点。
+package ruoCache
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cespare/xxhash/v2"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	pb "ruoCache/ruoCachePb"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	hotPath              = "_hot" // /<basepath>/_hot/<groupname>
+	sketchDepth          = 4
+	sketchWidth          = 1024
+	hotKeyCandidates     = 32               // 最多记录多少个候选热点 key
+	defaultDecayInterval = 10 * time.Second // 每隔多久计数减半
+	defaultHotThreshold  = 1000             // 一个衰减周期内访问多少次算热点
+	defaultHotLease      = 5 * time.Second  // 副本的租期
+)
+
+// 热点 key 及其估计的访问次数
+type HotKey struct {
+	Key   string
+	Count uint64
+}
+
+// 带衰减的 count-min sketch, 同时记录访问次数最多的若干个 key
+type hotKeys struct {
+	mutex      sync.Mutex
+	counters   [sketchDepth][sketchWidth]uint64
+	candidates map[string]uint64
+	lastDecay  time.Time
+	interval   time.Duration
+
+	threshold  uint64 // 0 表示不复制热点 key
+	lease      time.Duration
+	replicated map[string]time.Time // 最近一次发送副本的时间
+}
+
+func newHotKeys() *hotKeys {
+	return &hotKeys{
+		candidates: make(map[string]uint64),
+		lastDecay:  time.Now(),
+		interval:   defaultDecayInterval,
+		threshold:  defaultHotThreshold,
+		lease:      defaultHotLease,
+		replicated: make(map[string]time.Time),
+	}
+}
+
+// 记录一次访问, 返回估计的访问次数
+func (h *hotKeys) add(key string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.decay(time.Now())
+
+	// 用一个 64 位 hash 的高低两半组合出每一行的下标
+	sum := xxhash.Sum64String(key)
+	h1, h2 := uint32(sum), uint32(sum>>32)
+	count := ^uint64(0)
+	for i := range h.counters {
+		idx := (h1 + uint32(i)*h2) % sketchWidth
+		h.counters[i][idx]++
+		if h.counters[i][idx] < count {
+			count = h.counters[i][idx]
+		}
+	}
+
+	if _, ok := h.candidates[key]; ok || len(h.candidates) < hotKeyCandidates {
+		h.candidates[key] = count
+		return count
+	}
+	// 候选已满时替换掉次数最少的
+	minKey, minCount := "", count
+	for k, c := range h.candidates {
+		if c < minCount {
+			minKey, minCount = k, c
+		}
+	}
+	if minKey != "" {
+		delete(h.candidates, minKey)
+		h.candidates[key] = count
+	}
+	return count
+}
+
+// 每经过一个 interval 所有计数减半, 调用方需要持有锁。
+// 经过多个 interval 时一次移位, 空闲很久之后的第一次访问也只需要遍历一遍
+func (h *hotKeys) decay(now time.Time) {
+	n := now.Sub(h.lastDecay) / h.interval
+	if n <= 0 {
+		return
+	}
+	h.lastDecay = h.lastDecay.Add(n * h.interval)
+	shift := uint(64)
+	if n < 64 {
+		shift = uint(n)
+	}
+	for i := range h.counters {
+		for j := range h.counters[i] {
+			h.counters[i][j] >>= shift // 移位数不小于 64 时结果为 0
+		}
+	}
+	for k, c := range h.candidates {
+		if c >>= shift; c == 0 {
+			delete(h.candidates, k)
+		} else {
+			h.candidates[k] = c
+		}
+	}
+}
+
+// 访问次数最多的 n 个 key
+func (h *hotKeys) top(n int) []HotKey {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.decay(time.Now())
+
+	keys := make([]HotKey, 0, len(h.candidates))
+	for k, c := range h.candidates {
+		keys = append(keys, HotKey{Key: k, Count: c})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if n >= 0 && n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// 是否需要发送副本。租期过半之前不会重复发送
+func (h *hotKeys) shouldReplicate(key string, count uint64) (time.Duration, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.threshold == 0 || count < h.threshold {
+		return 0, false
+	}
+	now := time.Now()
+	if last, ok := h.replicated[key]; ok && now.Sub(last) < h.lease/2 {
+		return 0, false
+	}
+	for k, last := range h.replicated {
+		if now.Sub(last) >= h.lease {
+			delete(h.replicated, k)
+		}
+	}
+	h.replicated[key] = now
+	return h.lease, true
+}
+
+// 返回当前节点上访问次数最多的 n 个 key
+func (g *Group) HotKeys(n int) []HotKey {
+	return g.hotKeys.top(n)
+}
+
+// 设置热点 key 的阈值和副本的租期, threshold 为 0 时不复制热点 key
+func (g *Group) SetHotKeyPolicy(threshold uint64, lease time.Duration) {
+	g.hotKeys.mutex.Lock()
+	defer g.hotKeys.mutex.Unlock()
+	g.hotKeys.threshold = threshold
+	g.hotKeys.lease = lease
+}
+
+// key 成为热点后通知其他节点保存副本
+func (g *Group) replicateIfHot(key string, e *cacheEntry, count uint64) {
+	lease, ok := g.hotKeys.shouldReplicate(key, count)
+	if !ok {
+		return
+	}
+	broadcaster, ok := g.peers.(PeerBroadcaster)
+	if !ok {
+		return
+	}
+	value, err := e.view(key)
+	if err != nil {
+		return
+	}
+	g.logger.Printf("[RuoCache] hot key %s, replicate to peers", key)
+	in := &pb.Replica{
+		Group:   g.name,
+		Key:     key,
+		Value:   value.contiguous(),
+		LeaseMs: lease.Milliseconds(),
+		Version: e.value.version,
+		Tags:    e.tags,
+	}
+	for _, peer := range broadcaster.AllPeers() {
+		r, ok := peer.(Replicator)
+		if !ok {
+			continue
+		}
+		go func(peer Replicator) {
+			if err := peer.Replicate(in); err != nil {
+				g.logger.Printf("[RuoCache] Failed to replicate hot key %v", err)
+			}
+		}(r)
+	}
+}
+
+// 返回除自己以外的所有节点
+func (p *HttpPool) AllPeers() []PeerGetter {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	peers := make([]PeerGetter, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
+var _ PeerBroadcaster = (*HttpPool)(nil)
+
+// 接收热点 key 的副本
+func (p *HttpPool) serveReplica(w http.ResponseWriter, r *http.Request, groupName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := p.registry.GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxResponseSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	in := &pb.Replica{}
+	if err = proto.Unmarshal(body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 副本和 mainCache 中的缓存一样压缩、加密, 过期时间使用租期
+	e, err := group.newEntry(in.GetKey(), ByteView{b: in.GetValue(), version: in.GetVersion()}, in.GetTags())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.expire = time.Now().Add(time.Duration(in.GetLeaseMs()) * time.Millisecond)
+	group.hotCache.addIfNewer(in.GetKey(), e)
+
+	body, err = proto.Marshal(&pb.ReplicaResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+func (h *httpGetter) Replicate(in *pb.Replica) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%v%v/%v", h.baseURL, hotPath, url.PathEscape(in.GetGroup()))
+	res, err := h.client.Post(u, "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+var _ Replicator = (*httpGetter)(nil)
@@ -0,0 +1,103 @@
+package ruoCache
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHotKeys(t *testing.T) {
+	h := newHotKeys()
+	for i := 0; i < 100; i++ {
+		h.add("hot")
+	}
+	for i := 0; i < 100; i++ {
+		h.add("key" + strconv.Itoa(i))
+	}
+
+	top := h.top(1)
+	if len(top) != 1 || top[0].Key != "hot" || top[0].Count < 100 {
+		t.Fatalf("expect hot to be the hottest key, got %v", top)
+	}
+	if len(h.top(-1)) != hotKeyCandidates {
+		t.Fatalf("expect %d candidates, got %d", hotKeyCandidates, len(h.top(-1)))
+	}
+
+	// 经过一个衰减周期, 计数减半
+	h.lastDecay = h.lastDecay.Add(-h.interval)
+	if top = h.top(1); top[0].Count != 50 {
+		t.Fatalf("expect count to decay to 50, got %d", top[0].Count)
+	}
+	// 经过两个周期, 一次减为 1/4
+	h.lastDecay = h.lastDecay.Add(-2 * h.interval)
+	if top = h.top(1); top[0].Count != 12 {
+		t.Fatalf("expect count to decay to 12, got %d", top[0].Count)
+	}
+
+	// 空闲很久之后计数清零, 下一次衰减从现在开始计算
+	h.lastDecay = time.Now().Add(-1000000 * h.interval)
+	if c := h.add("hot"); c != 1 {
+		t.Fatalf("expect counters to be cleared after a long idle period, got %d", c)
+	}
+	if time.Since(h.lastDecay) >= h.interval {
+		t.Fatalf("lastDecay was not advanced: %v", h.lastDecay)
+	}
+}
+
+func TestHotKeyReplication(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t), newTestNode(t)}
+	urls := []string{nodes[0].server.URL, nodes[1].server.URL, nodes[2].server.URL}
+	for _, n := range nodes {
+		n.pool.Set(urls...)
+		n.group.SetHotKeyPolicy(5, 200*time.Millisecond)
+	}
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := "key" + strconv.Itoa(i); nodes[0].pool.peers.Get(k) == urls[0] {
+			key = k
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := nodes[0].group.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for _, n := range nodes[1:] {
+		for {
+			if v, ok := n.group.hotCache.get(key); ok {
+				if v.String() != "value of "+key {
+					t.Fatalf("unexpected replica %s", v)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("hot key %s was not replicated to %s", key, n.pool.self)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// 租期过后副本失效
+	time.Sleep(250 * time.Millisecond)
+	if _, ok := nodes[1].group.hotCache.get(key); ok {
+		t.Fatalf("replica of %s outlived its lease", key)
+	}
+}
+
+func TestReplicaTooLarge(t *testing.T) {
+	n := newTestNode(t)
+	body := bytes.NewReader(make([]byte, maxResponseSize+1))
+	res, err := http.Post(n.server.URL+defaultBasePath+hotPath+"/scores", "application/octet-stream", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expect 413 for an oversized replica, got %s", res.Status)
+	}
+}
@@ -3,8 +3,11 @@
 package ruoCache
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/golang/protobuf/proto"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -26,14 +29,34 @@ type HttpPool struct {
 	basePath   string
 	mutex      sync.Mutex
 	peers      *consistentHash.Map
+	hash       consistentHash.Hash // 为空时使用 consistentHash 的默认算法
 	httpGetters map[string]*httpGetter
+
+	registry    *Registry      // 节点上的所有分组
+	handoffRate int64          // 迁移缓存时每秒最多发送的字节数
+	handoffs    sync.WaitGroup // 正在进行的迁移
+	handoffStop chan struct{}  // 关闭后最近一次迁移停止
+	handoffDone chan struct{}  // 最近一次迁移结束后关闭
+
+	tlsConfig *tls.Config   // 为空时使用 HTTP
+	auth      Authenticator // 为空时不认证
+	acl       *ACL          // 为空时认证通过即可访问
+	signer    RequestSigner // 给发往其他节点的请求签名
+	client    *http.Client  // 访问其他节点, 根据上面的配置生成
 }
 
-// 实例化http资源池
+// 实例化http资源池, 使用 DefaultRegistry 中的分组
 func NewHttpPool(self string) *HttpPool {
+	return NewHttpPoolWithRegistry(self, DefaultRegistry)
+}
+
+// 实例化http资源池, 只提供 registry 中的分组
+func NewHttpPoolWithRegistry(self string, registry *Registry) *HttpPool {
 	return &HttpPool{
-		self:     self,
-		basePath: defaultBasePath,
+		self:        self,
+		basePath:    defaultBasePath,
+		registry:    registry,
+		handoffRate: defaultHandoffRate,
 	}
 }
 
@@ -46,6 +69,10 @@ func (p *HttpPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		panic("HttpPool serving unexpected path: " + r.URL.Path)
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
+	if !p.authorizePeer(r) {
+		http.Error(w, "peer certificate does not match any peer", http.StatusForbidden)
+		return
+	}
 	// /<basepath>/<groupname>/<key> required
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
 	if len(parts) != 2 {
@@ -53,22 +80,84 @@ func (p *HttpPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	groupName := parts[0]
+	// 在查找分组之前检查权限
+	groupName, perm := parts[0], PermRead
+	switch parts[0] {
+	case handoffPath, scanPath:
+		groupName, perm = parts[1], PermAdmin
+	case hotPath, tagPath:
+		groupName, perm = parts[1], PermWrite
+	case watchPath:
+		groupName = parts[1]
+	default:
+		if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+			perm = PermWrite
+		}
+	}
+	if !p.authorize(w, r, groupName, perm) {
+		return
+	}
+
+	switch parts[0] {
+	case handoffPath:
+		p.serveHandoff(w, r, parts[1])
+		return
+	case hotPath:
+		p.serveReplica(w, r, parts[1])
+		return
+	case tagPath:
+		p.serveInvalidateTag(w, r, parts[1])
+		return
+	case watchPath:
+		p.serveWatch(w, r, parts[1])
+		return
+	case scanPath:
+		p.serveScan(w, r, parts[1])
+		return
+	}
+
 	key := parts[1]
 
-	group := GetGroup(groupName)
+	group := p.registry.GetGroup(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
+	switch r.Method {
+	case http.MethodPut:
+		p.servePut(w, r, group, key)
+		return
+	case http.MethodDelete:
+		p.serveDelete(w, group, key)
+		return
+	case http.MethodHead:
+		p.serveVersion(w, group, key)
+		return
+	}
 
-	view, err := group.Get(key)
+	view, compression, err := group.get(key, r.URL.Query().Get("compression"))
+	if errors.Is(err, ErrOverloaded) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	if compression == "" && p.serveChunked(w, r, view) {
+		return
+	}
+	// Marshal 只读取 value, 不需要再复制一份
+	body, err := proto.Marshal(&pb.Response{
+		Value:       view.contiguous(),
+		Version:     view.Version(),
+		Compression: compression,
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -78,18 +167,54 @@ func (p *HttpPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
-// 启动的时候 设置节点
+// 设置节点, 节点变化时把不再属于自己的缓存迁移给新的节点
 func (p *HttpPool) Set(peers ...string) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
-	p.peers = consistentHash.New(defaultReplicas, nil)
+	changed := p.peers != nil
+	p.peers = consistentHash.New(defaultReplicas, p.hash)
 	p.peers.Add(peers...)
 	p.httpGetters = make(map[string]*httpGetter, len(peers))
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
+		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath, client: p.httpClient()}
+	}
+	if changed {
+		// 取消上一次还没有完成的迁移, 等它停下来再开始, 同一时间只有一次迁移
+		if p.handoffStop != nil {
+			close(p.handoffStop)
+		}
+		stop, done, prev := make(chan struct{}), make(chan struct{}), p.handoffDone
+		p.handoffStop, p.handoffDone = stop, done
+		p.handoffs.Add(1)
+		go func(ring *consistentHash.Map, getters map[string]*httpGetter) {
+			defer p.handoffs.Done()
+			defer close(done)
+			if prev != nil {
+				<-prev
+			}
+			p.handoff(ring, getters, stop)
+		}(p.peers, p.httpGetters)
 	}
 }
 
+// 设置迁移缓存时每秒最多发送的字节数, 必须大于 0
+func (p *HttpPool) SetHandoffRate(bytesPerSecond int64) error {
+	if bytesPerSecond <= 0 {
+		return fmt.Errorf("handoff rate must be positive: %d", bytesPerSecond)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.handoffRate = bytesPerSecond
+	return nil
+}
+
+// 设置一致性hash使用的hash算法, 所有节点必须一致, 需要在 Set 之前调用
+func (p *HttpPool) SetHash(fn consistentHash.Hash) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.hash = fn
+}
+
 // 选择节点
 func (p *HttpPool) PickPeer(key string) ( PeerGetter, bool)  {
 	p.mutex.Lock()
@@ -105,29 +230,50 @@ var _ PeerPicker = (*HttpPool)(nil)
 
 type httpGetter struct {
 	baseURL string
+	client  *http.Client
 }
 
 func (h *httpGetter) Get(in *pb.Request, out *pb.Response) (error) {
-	u := fmt.Sprintf(
-		"%v%v/%v",
-		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
-	)
-	res, err := http.Get(u)
+	res, err := h.client.Get(h.valueURL(in))
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", in.GetKey(), ErrNotFound)
+	}
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("server returned: %v", res.Status)
 	}
-	bytes, err := ioutil.ReadAll(res.Body)
+	return readResponse(res.Body, out)
+}
+
+// 查询缓存值的地址, 带上能够解压的压缩算法
+func (h *httpGetter) valueURL(in *pb.Request) string {
+	u := fmt.Sprintf(
+		"%v%v/%v",
+		h.baseURL,
+		url.PathEscape(in.GetGroup()),
+		url.PathEscape(in.GetKey()),
+	)
+	if c := in.GetAcceptCompression(); c != "" {
+		u += "?compression=" + url.QueryEscape(c)
+	}
+	return u
+}
+
+func readResponse(r io.Reader, out *pb.Response) error {
+	bytes, err := ioutil.ReadAll(io.LimitReader(r, maxResponseSize+1))
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if len(bytes) > maxResponseSize {
+		return fmt.Errorf("response too large: more than %d bytes", maxResponseSize)
+	}
 	if err = proto.Unmarshal(bytes, out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
-
 	return nil
 }
 
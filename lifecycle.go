@@ -0,0 +1,58 @@
+// 分组的生命周期: 名称重复时报错而不是覆盖, 可以删除分组、调整大小。
+// 关闭后分组释放所有缓存, 停止写回协程并关闭所有订阅, 之后的读写返回 ErrGroupClosed。
+package ruoCache
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// 分组名称已经存在
+	ErrGroupExists = errors.New("group already exists")
+	// 分组已经关闭
+	ErrGroupClosed = errors.New("group closed")
+)
+
+// 从 DefaultRegistry 删除并关闭分组
+func DeleteGroup(name string) error {
+	return DefaultRegistry.DeleteGroup(name)
+}
+
+func (g *Group) isClosed() bool {
+	return atomic.LoadInt32(&g.closed) == 1
+}
+
+// 关闭分组: 从 Registry 中移除, 写入剩余的脏数据, 释放缓存并关闭所有订阅。重复调用直接返回
+func (g *Group) Close() error {
+	var err error
+	g.closeOnce.Do(func() {
+		atomic.StoreInt32(&g.closed, 1)
+		if g.behind != nil {
+			err = g.behind.close()
+		}
+		if g.registry != nil {
+			g.registry.remove(g)
+		}
+		if g.memory != nil {
+			g.memory.unregister(g)
+		}
+		g.mainCache.clear()
+		g.hotCache.clear()
+		g.watchers.close()
+	})
+	return err
+}
+
+// 调整缓存大小, 超出的部分立即淘汰。hotCache 保持创建时和 mainCache 的比例,
+// 创建时 mainCache 不限大小或者 hotCache 不限大小的保持不变
+func (g *Group) Resize(cacheBytes int64) {
+	g.mainCache.resize(cacheBytes)
+	if g.hotRatio > 0 {
+		hot := int64(float64(cacheBytes) * g.hotRatio)
+		if hot < 1 {
+			hot = 1 // 0 表示不限大小
+		}
+		g.hotCache.resize(hot)
+	}
+}
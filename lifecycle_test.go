@@ -0,0 +1,118 @@
+package ruoCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestGroupLifecycle(t *testing.T) {
+	s := &memStore{data: make(map[string]string)}
+	g := NewGroup("lifecycle", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	g.RegisterStore(s, WriteBehind)
+
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			if !errors.Is(err, ErrGroupExists) {
+				t.Fatalf("expect ErrGroupExists, got %v", err)
+			}
+		}()
+		MustNewGroup("lifecycle", 2<<10, g.getter)
+	}()
+	if _, err := NewGroupWithOptions("lifecycle", g.getter); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expect ErrGroupExists, got %v", err)
+	}
+	if GetGroup("lifecycle") != g {
+		t.Fatalf("duplicate NewGroup overwrote the existing group")
+	}
+
+	events := g.Watch(context.Background(), "*")
+	g.Set("tom", "630")
+	<-events
+
+	if err := DeleteGroup("lifecycle"); err != nil {
+		t.Fatal(err)
+	}
+	if GetGroup("lifecycle") != nil {
+		t.Fatalf("group should be unregistered")
+	}
+	if v, _ := s.get("tom"); v != "630" {
+		t.Fatalf("dirty entries were not flushed on close")
+	}
+	if _, ok := <-events; ok {
+		t.Fatalf("watch channel should be closed")
+	}
+	if g.mainCache.bytes() != 0 {
+		t.Fatalf("memory was not released")
+	}
+	if _, err := g.Get("tom"); err != ErrGroupClosed {
+		t.Fatalf("expect ErrGroupClosed, got %v", err)
+	}
+	if err := g.Set("tom", "631"); err != ErrGroupClosed {
+		t.Fatalf("expect ErrGroupClosed, got %v", err)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatalf("close twice should be a no-op, got %v", err)
+	}
+	if err := DeleteGroup("lifecycle"); err == nil {
+		t.Fatalf("expect error when deleting a missing group")
+	}
+}
+
+// 直接关闭的分组也会从 Registry 中移除
+func TestCloseUnregisters(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	})
+	old := r.MustNewGroup("scores", 2<<10, getter)
+	old.Close()
+	if r.GetGroup("scores") != nil {
+		t.Fatalf("closed group should not be resolvable")
+	}
+
+	g, err := r.NewGroupWithOptions("scores", getter)
+	if err != nil {
+		t.Fatalf("name of a closed group should be reusable: %v", err)
+	}
+	old.Close()
+	if r.GetGroup("scores") != g {
+		t.Fatalf("closing an old group again should not remove the new one")
+	}
+}
+
+func TestResize(t *testing.T) {
+	g := newGroup("resize", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	for i := 0; i < 8; i++ {
+		g.Set(fmt.Sprintf("key%d", i), "value")
+	}
+	before := g.mainCache.bytes()
+	g.Resize(before / 2)
+	if after := g.mainCache.bytes(); after > before/2 || after == 0 {
+		t.Fatalf("expect cache to shrink to %d, got %d", before/2, after)
+	}
+	if _, ok := g.mainCache.get("key7"); !ok {
+		t.Fatalf("the most recently used key should survive the resize")
+	}
+	if hot := g.hotCache.cacheBytes; hot != before/2/8 {
+		t.Fatalf("expect the default hot cache to stay at 1/8, got %d", hot)
+	}
+
+	// 配置的 hotCache 大小按比例调整, 不会被改回 1/8
+	g, err := buildGroup("resize-hot", GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}), WithCacheBytes(4<<10), WithHotCacheBytes(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Resize(8 << 10)
+	if hot := g.hotCache.cacheBytes; hot != 4<<10 {
+		t.Fatalf("expect the configured hot cache ratio to be kept, got %d", hot)
+	}
+}
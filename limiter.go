@@ -0,0 +1,84 @@
+package ruoCache
+
+import (
+	"sync"
+	"time"
+)
+
+// 令牌桶限流, 每秒补充 rate 个令牌, 最多积攒 burst 个
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// rate 必须大于 0, 否则计算等待时间时除以 0
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if rate <= 0 {
+		panic("token bucket rate must be positive")
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// 补充令牌, 调用方需要持有锁
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// 取走 n 个令牌, 不够时返回需要等待的时间。
+// n 大于 burst 时也允许透支, 否则永远拿不到
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// 阻塞直到拿到 n 个令牌
+func (b *tokenBucket) wait(n float64) {
+	if d := b.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// 需要等待的时间不超过 maxWait 时取走 n 个令牌并返回等待时间, 否则不取走令牌
+func (b *tokenBucket) reserveWithin(n float64, maxWait time.Duration) (time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(time.Now())
+	var d time.Duration
+	if b.tokens < n {
+		d = time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+	}
+	if d > maxWait {
+		return 0, false
+	}
+	b.tokens -= n
+	return d, true
+}
+
+// 归还取走但没有使用的 n 个令牌
+func (b *tokenBucket) refund(n float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(time.Now())
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
@@ -5,6 +5,18 @@ import (
 	"log"
 )
 
+// 64 位平台上每个条目的额外开销估计: list.Element 48 字节, entry 48 字节,
+// map 槽位加上扩容预留的空间平均约 48 字节 (按内存分配的 size class 计算)
+const DefaultEntryOverhead = 144
+
+// 淘汰策略
+type Policy int
+
+const (
+	LRU  Policy = iota // 淘汰最久未访问的条目
+	FIFO               // 淘汰最早写入的条目, 访问和覆盖写入不改变顺序
+)
+
 type Cache struct {
 	maxBytes int64 //允许使用的最大内存，
 	nbytes   int64 // 当前已使用的内存
@@ -12,12 +24,20 @@ type Cache struct {
 	ll    *list.List // 双向列表
 	cache map[string]*list.Element
 
+	// 每个条目除了 key 和 value 以外额外占用的内存, 即链表节点、map 槽位和 entry 本身。
+	// 默认为 0, 只统计 key 和 value。修改后只对之后添加的条目生效
+	EntryOverhead int64
+
+	// 淘汰策略, 默认为 LRU
+	Policy Policy
+
 	OnEvicted func(key string, value Value)
 }
 
 type entry struct {
 	key   string
 	value Value
+	cost  int64 // 添加时计算的内存占用, 删除时原样减去
 }
 
 // Value use Len to count how many bytes it takes
@@ -25,6 +45,20 @@ type Value interface {
 	Len() int
 }
 
+// Value 可以实现 Coster, 用 Cost 代替 Len 计算占用的内存, 把值本身的结构体等开销也算进去
+type Coster interface {
+	Cost() int64
+}
+
+// 计算一个条目占用的内存
+func (c *Cache) cost(key string, value Value) int64 {
+	n := int64(len(key)) + c.EntryOverhead
+	if v, ok := value.(Coster); ok {
+		return n + v.Cost()
+	}
+	return n + int64(value.Len())
+}
+
 // 方便实例化cache
 func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
 	return &Cache{
@@ -38,13 +72,23 @@ func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
 // 如果键对应的链表节点存在，则将对应节点移动到队尾，并返回查找到的值
 func (c *Cache) Get(key string) (value Value, ok bool) {
 	if ele, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(ele) // 能获取到缓存 则将其移动到队尾
+		if c.Policy == LRU {
+			c.ll.MoveToFront(ele) // 能获取到缓存 则将其移动到队尾
+		}
 		kv := ele.Value.(*entry)
 		return kv.value, true
 	}
 	return
 }
 
+// 查找缓存, 与 Get 不同的是不会改变使用顺序
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		return ele.Value.(*entry).value, true
+	}
+	return
+}
+
 // 移出元素
 func (c *Cache) RemoveOldest() {
 	// 如果列表为空，则返回 / 返回列表l或nil的最后一个元素。
@@ -53,8 +97,8 @@ func (c *Cache) RemoveOldest() {
 	if ele != nil {
 		c.ll.Remove(ele) // 移出元素
 		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)                                // 从缓存中删除
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len()) // 重新计算当前已用内存
+		delete(c.cache, kv.key) // 从缓存中删除
+		c.nbytes -= kv.cost     // 重新计算当前已用内存
 		// 如果回调函数 OnEvicted 不为 nil，则调用回调函数。
 		if c.OnEvicted != nil {
 			c.OnEvicted(kv.key, kv.value)
@@ -66,24 +110,73 @@ func (c *Cache) RemoveOldest() {
 func (c *Cache) Add(key string, value Value) {
 	// 元素已存在 则更新
 	if ele, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(ele)
+		if c.Policy == LRU {
+			c.ll.MoveToFront(ele)
+		}
 		kv := ele.Value.(*entry)
-		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
-		log.Printf("key %s is exists , update to %s", key, value)
+		cost := c.cost(key, value)
+		c.nbytes += cost - kv.cost
+		log.Printf("key %s is exists , update", key)
 		kv.value = value
+		kv.cost = cost
 	} else {
-		ele := c.ll.PushFront(&entry{key, value})
+		cost := c.cost(key, value)
+		ele := c.ll.PushFront(&entry{key, value, cost})
 		c.cache[key] = ele
-		c.nbytes += int64(len(key)) + int64(value.Len())
+		c.nbytes += cost
 	}
 	log.Printf("current memory %d max memory %d", c.nbytes, c.maxBytes)
-	// 当内存不足时, 检测并移出没有使用的
+	c.evict()
+}
+
+// 修改允许使用的最大内存, 超出的部分立即淘汰
+func (c *Cache) SetMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// 当内存不足时, 检测并移出没有使用的
+func (c *Cache) evict() {
 	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
 		log.Printf("current memory %d  > max memory %d clear the Least Recently Used", c.nbytes, c.maxBytes)
 		c.RemoveOldest()
 	}
 }
 
+// 主动删除缓存, 不会触发 OnEvicted
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.ll.Remove(ele)
+		kv := ele.Value.(*entry)
+		delete(c.cache, kv.key)
+		c.nbytes -= kv.cost
+	}
+}
+
+// 从最近使用到最久未使用遍历缓存, fn 返回 false 时停止, 不会改变使用顺序。
+// fn 中不能修改缓存
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		kv := ele.Value.(*entry)
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
+// 从最近使用到最久未使用返回所有的 key
+func (c *Cache) Keys() []string {
+	keys := make([]string, 0, c.ll.Len())
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		keys = append(keys, ele.Value.(*entry).key)
+	}
+	return keys
+}
+
+// 当前已使用的内存
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
 
 // Len the number of cache entries
 func (c *Cache) Len() int {
@@ -53,4 +53,76 @@ func TestOnEvicted(t *testing.T) {
 	if !reflect.DeepEqual(expect, keys) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
-}
\ No newline at end of file
+}
+
+func TestIterate(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("k1", String("v1"))
+	lru.Add("k2", String("v2"))
+	lru.Add("k3", String("v3"))
+
+	// Peek 不改变使用顺序
+	if v, ok := lru.Peek("k1"); !ok || string(v.(String)) != "v1" {
+		t.Fatalf("peek k1 failed")
+	}
+	if keys := lru.Keys(); !reflect.DeepEqual(keys, []string{"k3", "k2", "k1"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	lru.Get("k1")
+	var keys []string
+	lru.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	if !reflect.DeepEqual(keys, []string{"k1", "k3"}) {
+		t.Fatalf("unexpected range %v", keys)
+	}
+
+	lru.Remove("k3")
+	if _, ok := lru.Peek("k3"); ok || lru.Len() != 2 {
+		t.Fatalf("remove k3 failed")
+	}
+}
+
+type costly string
+
+func (c costly) Len() int {
+	return len(c)
+}
+
+func (c costly) Cost() int64 {
+	return int64(len(c)) + 100
+}
+
+func TestEntryOverhead(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.EntryOverhead = 10
+	lru.Add("k1", String("v1"))
+	lru.Add("k2", costly("v2"))
+	if lru.Bytes() != (2+2+10)+(2+2+10+100) {
+		t.Fatalf("unexpected bytes %d", lru.Bytes())
+	}
+	lru.Add("k2", String("v22"))
+	lru.Remove("k1")
+	if lru.Bytes() != 2+3+10 {
+		t.Fatalf("unexpected bytes %d after update and remove", lru.Bytes())
+	}
+}
+
+func TestFIFO(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	lru := New(int64(len(k1+k2+v1+v2)), nil)
+	lru.Policy = FIFO
+	lru.Add(k1, String(v1))
+	lru.Add(k2, String(v2))
+	// 访问和覆盖写入都不会让 key1 变新
+	lru.Get(k1)
+	lru.Add(k1, String(v1))
+	lru.Add(k3, String(v3))
+
+	if _, ok := lru.Get(k1); ok || lru.Len() != 2 {
+		t.Fatalf("FIFO should evict the first written key1")
+	}
+}
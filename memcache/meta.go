@@ -0,0 +1,281 @@
+package memcache
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"ruoCache"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// meta 命令的标志, 每个标志是一个字母, 后面可以跟参数
+type metaFlags map[byte]string
+
+func parseMetaFlags(tokens []string) (metaFlags, error) {
+	flags := make(metaFlags, len(tokens))
+	for _, t := range tokens {
+		if t == "" {
+			continue
+		}
+		flags[t[0]] = t[1:]
+	}
+	if _, ok := flags['b']; ok {
+		return nil, clientError("base64 keys are not supported")
+	}
+	return flags, nil
+}
+
+func (f metaFlags) has(flag byte) bool {
+	_, ok := f[flag]
+	return ok
+}
+
+// 按请求的标志生成回复中的返回标志
+func (f metaFlags) ret(key string, v ruoCache.ByteView, ttl time.Duration, hasTTL bool) string {
+	var out []string
+	for _, flag := range []byte("kcstfO") {
+		if !f.has(flag) {
+			continue
+		}
+		switch flag {
+		case 'k':
+			out = append(out, "k"+key)
+		case 'c':
+			out = append(out, "c"+strconv.FormatUint(v.Version(), 10))
+		case 's':
+			out = append(out, "s"+strconv.Itoa(v.Len()))
+		case 't':
+			secs := int64(-1)
+			if hasTTL && ttl >= 0 {
+				secs = int64((ttl + time.Second - 1) / time.Second)
+			}
+			out = append(out, "t"+strconv.FormatInt(secs, 10))
+		case 'f':
+			out = append(out, "f0")
+		case 'O':
+			out = append(out, "O"+f['O'])
+		}
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return " " + strings.Join(out, " ")
+}
+
+// 只返回 k 和 O, 用于没有值的回复
+func (f metaFlags) echo(key string) string {
+	var out []string
+	if f.has('k') {
+		out = append(out, "k"+key)
+	}
+	if f.has('O') {
+		out = append(out, "O"+f['O'])
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return " " + strings.Join(out, " ")
+}
+
+// mg <key> <flags>*
+// 支持 v k c s t f O q T<ttl>, 命中时返回 VA 或 HD, 未命中时返回 EN (q 时不回复)
+func (sess *session) metaGet(args []string) {
+	if len(args) == 0 {
+		sess.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+	key := args[0]
+	if err := checkKey(key); err != nil {
+		sess.clientError(err)
+		return
+	}
+	flags, err := parseMetaFlags(args[1:])
+	if err != nil {
+		sess.clientError(err)
+		return
+	}
+	var touch time.Duration
+	if flags.has('T') {
+		if touch, err = parseExptime(flags['T']); err != nil {
+			sess.clientError(err)
+			return
+		}
+	}
+
+	v, ok, err := sess.lookup(key)
+	if err != nil {
+		sess.serverError(err)
+		return
+	}
+	if !ok {
+		if !flags.has('q') {
+			sess.reply("EN")
+		}
+		return
+	}
+	g, k := sess.server.route(key)
+	if flags.has('T') {
+		if touch == 0 {
+			g.Persist(k)
+		} else {
+			g.Expire(k, touch)
+		}
+	}
+	ttl, hasTTL := g.TTL(k)
+	ret := flags.ret(key, v, ttl, hasTTL)
+	if !flags.has('v') {
+		sess.reply("HD" + ret)
+		return
+	}
+	sess.reply("VA " + strconv.Itoa(v.Len()) + ret)
+	v.WriteTo(sess.w)
+	sess.reply("")
+}
+
+// ms <key> <datalen> <flags>*
+// 支持 k O q T<ttl> C<cas> F<flags> 和 M<mode>: S 写入 (默认), E 不存在时添加, R 存在时替换。
+// c 只在比较版本号写入 (C 或 ME) 时返回新的 CAS 值。
+// 返回 HD, 未写入时返回 NS, CAS 不一致时返回 EX, CAS 时 key 不存在返回 NF
+func (sess *session) metaSet(args []string) error {
+	if len(args) < 2 {
+		sess.reply("CLIENT_ERROR bad command line format")
+		return nil
+	}
+	size, err := strconv.Atoi(args[1])
+	if err != nil || size < 0 {
+		return clientError("bad data chunk")
+	}
+	if size > maxItemBytes {
+		if _, err := io.CopyN(ioutil.Discard, sess.r, int64(size)+2); err != nil {
+			return err
+		}
+		sess.reply("SERVER_ERROR object too large for cache")
+		return nil
+	}
+	data, err := sess.readData(size)
+	if err != nil {
+		return err
+	}
+
+	key := args[0]
+	if err := checkKey(key); err != nil {
+		sess.clientError(err)
+		return nil
+	}
+	flags, err := parseMetaFlags(args[2:])
+	if err != nil {
+		sess.clientError(err)
+		return nil
+	}
+	var ttl time.Duration
+	if flags.has('T') {
+		if ttl, err = parseExptime(flags['T']); err != nil {
+			sess.clientError(err)
+			return nil
+		}
+	}
+	var cas uint64
+	if flags.has('C') {
+		if cas, err = strconv.ParseUint(flags['C'], 10, 64); err != nil {
+			sess.clientError(clientError("bad token in command line format"))
+			return nil
+		}
+	}
+	mode := "S"
+	if flags.has('M') {
+		mode = strings.ToUpper(flags['M'])
+	}
+	g, k := sess.server.route(key)
+	if g == nil {
+		sess.serverError(errors.New("no such group"))
+		return nil
+	}
+
+	var version uint64
+	switch {
+	case mode != "S" && mode != "E" && mode != "R":
+		sess.clientError(clientError("invalid mode for ms"))
+		return nil
+	case flags.has('C'):
+		version, err = compareAndSet(g, k, cas, string(data), ttl)
+		if errors.Is(err, errNotCached) {
+			sess.reply("NF" + flags.echo(key))
+			return nil
+		}
+	case mode == "E":
+		version, err = g.CompareAndSetOnOwner(k, 0, string(data), ttl)
+	case mode == "R":
+		var stored bool
+		if stored, version, err = replace(g, k, string(data), ttl); err == nil && !stored {
+			sess.reply("NS" + flags.echo(key))
+			return nil
+		}
+	default:
+		err = g.SetOnOwner(k, string(data), ttl)
+	}
+	if errors.Is(err, ruoCache.ErrVersionMismatch) {
+		if mode == "E" {
+			sess.reply("NS" + flags.echo(key))
+		} else {
+			sess.reply("EX" + flags.echo(key))
+		}
+		return nil
+	}
+	if err == nil {
+		err = expireNow(g, k, ttl)
+	}
+	if err != nil {
+		sess.serverError(err)
+		return nil
+	}
+	if flags.has('q') {
+		return nil
+	}
+	out := "HD" + flags.echo(key)
+	if flags.has('c') && version != 0 {
+		out += " c" + strconv.FormatUint(version, 10)
+	}
+	sess.reply(out)
+	return nil
+}
+
+// md <key> <flags>*, 支持 k O q。返回 HD, key 不存在时返回 NF, q 时都不回复
+func (sess *session) metaDelete(args []string) {
+	if len(args) == 0 {
+		sess.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+	key := args[0]
+	if err := checkKey(key); err != nil {
+		sess.clientError(err)
+		return
+	}
+	flags, err := parseMetaFlags(args[1:])
+	if err != nil {
+		sess.clientError(err)
+		return
+	}
+	if flags.has('C') || flags.has('I') {
+		sess.clientError(clientError("C and I flags are not supported for md"))
+		return
+	}
+	g, k := sess.server.route(key)
+	if g == nil {
+		sess.serverError(errors.New("no such group"))
+		return
+	}
+	existed, err := g.DeleteOnOwner(k)
+	if err != nil {
+		sess.serverError(err)
+		return
+	}
+	switch {
+	case flags.has('q'):
+	case !existed:
+		sess.reply("NF" + flags.echo(key))
+	default:
+		sess.reply("HD" + flags.echo(key))
+	}
+}
@@ -0,0 +1,360 @@
+// Package memcache 提供兼容 memcached 文本协议和 meta 命令的 TCP 服务, 供使用 memcached 客户端的服务访问分组。
+// key 按前缀路由到分组: "<分组名><Separator><key>" 中的分组存在时访问该分组中的 key,
+// 否则整个 key 都属于默认分组 Server.Group。
+// gets/cas 使用的 CAS 值就是缓存的版本号。客户端的 flags 不会保存, 读取时总是返回 0。
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"ruoCache"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 调用 Close 之后 Serve 返回的错误
+var ErrServerClosed = errors.New("memcache: server closed")
+
+const (
+	defaultSeparator = ":"
+	idleTimeout      = 5 * time.Minute // 连接空闲多久后关闭
+	maxLineBytes     = 64 << 10        // 命令行的最大长度
+	maxKeyBytes      = 250             // 和 memcached 一致
+	maxItemBytes     = 64 << 20        // 一个值的最大长度
+)
+
+type Server struct {
+	Registry  *ruoCache.Registry // 为空时使用 ruoCache.DefaultRegistry
+	Group     string             // 没有分组前缀的 key 所在的分组
+	Separator string             // 分组名和 key 之间的分隔符, 为空时使用 ":"
+
+	mutex     sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	started   time.Time
+	stats     stats
+}
+
+// stats 命令返回的计数
+type stats struct {
+	currConns  int64
+	totalConns uint64
+	cmdGet     uint64
+	getHits    uint64
+	getMisses  uint64
+	cmdSet     uint64
+	cmdTouch   uint64
+}
+
+// 在 addr 上监听并提供服务
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// 接受 l 上的连接, 每个连接一个协程。返回时 l 已经关闭
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.track(l, false)
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// 关闭所有监听和连接
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (s *Server) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+func (s *Server) track(l net.Listener, add bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+		s.started = time.Now()
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) trackConn(c net.Conn, add bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !add {
+		delete(s.conns, c)
+		atomic.AddInt64(&s.stats.currConns, -1)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	atomic.AddInt64(&s.stats.currConns, 1)
+	atomic.AddUint64(&s.stats.totalConns, 1)
+	return true
+}
+
+func (s *Server) registry() *ruoCache.Registry {
+	if s.Registry == nil {
+		return ruoCache.DefaultRegistry
+	}
+	return s.Registry
+}
+
+// 找到 key 所在的分组和分组中的 key, 没有对应的分组时返回 nil
+func (s *Server) route(key string) (*ruoCache.Group, string) {
+	sep := s.Separator
+	if sep == "" {
+		sep = defaultSeparator
+	}
+	if i := strings.Index(key, sep); i > 0 {
+		if g := s.registry().GetGroup(key[:i]); g != nil {
+			return g, key[i+len(sep):]
+		}
+	}
+	return s.registry().GetGroup(s.Group), key
+}
+
+// 客户端发送的内容不合法, 回复 CLIENT_ERROR
+type clientError string
+
+func (e clientError) Error() string {
+	return string(e)
+}
+
+// 一个客户端连接
+type session struct {
+	server *Server
+	r      *bufio.Reader
+	w      *bufio.Writer
+	quit   bool
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer c.Close()
+	if !s.trackConn(c, true) {
+		return
+	}
+	defer s.trackConn(c, false)
+
+	sess := &session{
+		server: s,
+		r:      bufio.NewReaderSize(c, maxLineBytes),
+		w:      bufio.NewWriter(c),
+	}
+	for !sess.quit {
+		c.SetReadDeadline(time.Now().Add(idleTimeout))
+		line, err := sess.readLine()
+		if err != nil {
+			var ce clientError
+			if errors.As(err, &ce) {
+				sess.clientError(ce)
+				sess.w.Flush()
+			}
+			return
+		}
+		if err := sess.dispatch(line); err != nil {
+			// 数据块不完整时无法再找到下一条命令的开头, 只能关闭连接
+			var ce clientError
+			if errors.As(err, &ce) {
+				sess.clientError(ce)
+			}
+			sess.w.Flush()
+			return
+		}
+		// 缓冲区中还有命令时先不发送, 一批命令的回复合并写出
+		if sess.r.Buffered() == 0 {
+			if err := sess.w.Flush(); err != nil {
+				log.Printf("[RuoCache] memcache: write to %s: %v", c.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+	sess.w.Flush()
+}
+
+// 读取一行命令, 去掉结尾的 \r\n
+func (sess *session) readLine() (string, error) {
+	line, err := sess.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", clientError("line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// 读取 n 字节的数据块和结尾的 \r\n
+func (sess *session) readData(n int) ([]byte, error) {
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(sess.r, buf); err != nil {
+		return nil, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, clientError("bad data chunk")
+	}
+	return buf[:n], nil
+}
+
+func (sess *session) reply(s string) {
+	sess.w.WriteString(s + "\r\n")
+}
+
+func (sess *session) clientError(err error) {
+	sess.reply("CLIENT_ERROR " + err.Error())
+}
+
+func (sess *session) serverError(err error) {
+	sess.reply("SERVER_ERROR " + strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error()))
+}
+
+// 处理一条命令, 返回错误时关闭连接
+func (sess *session) dispatch(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		sess.reply("ERROR")
+		return nil
+	}
+	switch name, args := fields[0], fields[1:]; name {
+	case "get", "gets":
+		sess.get(args, name == "gets")
+	case "set", "add", "replace", "cas":
+		return sess.store(name, args)
+	case "delete":
+		sess.delete(args)
+	case "touch":
+		sess.touch(args)
+	case "stats":
+		sess.stats(args)
+	case "version":
+		sess.reply("VERSION ruocache")
+	case "quit":
+		sess.quit = true
+	case "mg":
+		sess.metaGet(args)
+	case "ms":
+		return sess.metaSet(args)
+	case "md":
+		sess.metaDelete(args)
+	case "mn":
+		sess.reply("MN")
+	default:
+		sess.reply("ERROR")
+	}
+	return nil
+}
+
+// 检查 key 是否合法
+func checkKey(key string) error {
+	if len(key) == 0 || len(key) > maxKeyBytes {
+		return clientError("bad key length")
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return clientError("bad key")
+		}
+	}
+	return nil
+}
+
+// 读取一个 key, 数据源返回错误时当作 key 不存在
+func (sess *session) lookup(key string) (ruoCache.ByteView, bool, error) {
+	atomic.AddUint64(&sess.server.stats.cmdGet, 1)
+	g, k := sess.server.route(key)
+	if g == nil {
+		return ruoCache.ByteView{}, false, errors.New("no such group")
+	}
+	v, err := g.Get(k)
+	if err == nil {
+		atomic.AddUint64(&sess.server.stats.getHits, 1)
+		return v, true, nil
+	}
+	atomic.AddUint64(&sess.server.stats.getMisses, 1)
+	if errors.Is(err, ruoCache.ErrOverloaded) || errors.Is(err, ruoCache.ErrGroupClosed) {
+		return v, false, err
+	}
+	return v, false, nil
+}
+
+// memcached 的过期时间: 不超过 30 天时是相对时间, 否则是 unix 时间戳, 负数表示立即过期
+func parseExptime(s string) (time.Duration, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, clientError("bad command line format")
+	}
+	switch {
+	case n == 0:
+		return 0, nil
+	case n < 0:
+		return -1, nil
+	case n <= 30*24*3600:
+		return time.Duration(n) * time.Second, nil
+	}
+	d := time.Until(time.Unix(n, 0))
+	if d <= 0 {
+		return -1, nil
+	}
+	return d, nil
+}
+
+// 写入时过期时间为负数表示立即过期, 写入之后从负责节点删除
+func expireNow(g *ruoCache.Group, key string, ttl time.Duration) error {
+	if ttl >= 0 {
+		return nil
+	}
+	_, err := g.DeleteOnOwner(key)
+	return err
+}
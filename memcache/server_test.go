@@ -0,0 +1,292 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"ruoCache"
+	pb "ruoCache/ruoCachePb"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var db = map[string]string{
+	"Tom":  "630",
+	"Jack": "589",
+	"Sam":  "567",
+}
+
+func newTestServer(t *testing.T) (string, *Server) {
+	r := ruoCache.NewRegistry()
+	r.NewGroup("scores", 2<<10, ruoCache.GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	r.NewGroup("names", 2<<10, ruoCache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("name:" + key), nil
+	}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Registry: r, Group: "scores"}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String(), s
+}
+
+// 一步脚本: 发送 send 后期望收到 expect。expect 是正则表达式, 用来匹配 CAS 值等不固定的内容
+type step struct {
+	send   string
+	expect string
+}
+
+// 在一个连接上依次执行脚本, 返回每一步匹配到的子表达式
+func runScript(t *testing.T, addr string, steps []step) [][]string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	var matches [][]string
+	for i, s := range steps {
+		if _, err := io.WriteString(conn, s.send); err != nil {
+			t.Fatal(err)
+		}
+		re := regexp.MustCompile(`^` + s.expect + `$`)
+		// 按行读取直到匹配, 回复不会超过期望的行数
+		var got string
+		for n := strings.Count(s.expect, `\r\n`); n > 0; n-- {
+			line, err := r.ReadString('\n')
+			got += line
+			if err != nil {
+				t.Fatalf("step %d %q: %v (got %q)", i, s.send, err, got)
+			}
+		}
+		m := re.FindStringSubmatch(got)
+		if m == nil {
+			t.Fatalf("step %d %q: expect %q, got %q", i, s.send, s.expect, got)
+		}
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+func TestTextProtocol(t *testing.T) {
+	addr, _ := newTestServer(t)
+	runScript(t, addr, []step{
+		{"get Tom\r\n", `VALUE Tom 0 3\r\n630\r\nEND\r\n`},
+		{"get Tom unknown Jack\r\n", `VALUE Tom 0 3\r\n630\r\nVALUE Jack 0 3\r\n589\r\nEND\r\n`},
+		{"set k 5 0 5\r\nhello\r\n", `STORED\r\n`},
+		{"get k\r\n", `VALUE k 0 5\r\nhello\r\nEND\r\n`},
+		{"add k 0 0 1\r\nx\r\n", `NOT_STORED\r\n`},
+		{"add n 0 0 1\r\nx\r\n", `STORED\r\n`},
+		{"replace missing 0 0 1\r\nx\r\n", `NOT_STORED\r\n`},
+		{"replace n 0 0 1\r\ny\r\n", `STORED\r\n`},
+		{"get n\r\n", `VALUE n 0 1\r\ny\r\nEND\r\n`},
+		{"delete n\r\n", `DELETED\r\n`},
+		{"delete n\r\n", `NOT_FOUND\r\n`},
+		{"touch k 100\r\n", `TOUCHED\r\n`},
+		{"touch missing 100\r\n", `NOT_FOUND\r\n`},
+		{"set k 0 -1 1\r\nx\r\n", `STORED\r\n`},
+		{"get k\r\n", `END\r\n`},
+		{"set quiet 0 0 1 noreply\r\nq\r\nget quiet\r\n", `VALUE quiet 0 1\r\nq\r\nEND\r\n`},
+		{"version\r\n", `VERSION ruocache\r\n`},
+		{"bogus\r\n", `ERROR\r\n`},
+		{"get " + strings.Repeat("k", 251) + "\r\n", `CLIENT_ERROR bad key length\r\n`},
+	})
+}
+
+// 一次写入多条命令
+func TestPipelining(t *testing.T) {
+	addr, _ := newTestServer(t)
+	runScript(t, addr, []step{
+		{"set a 0 0 1\r\n1\r\nset b 0 0 1\r\n2\r\nget a b\r\ndelete a\r\nget a b\r\n",
+			`STORED\r\nSTORED\r\nVALUE a 0 1\r\n1\r\nVALUE b 0 1\r\n2\r\nEND\r\nDELETED\r\nVALUE b 0 1\r\n2\r\nEND\r\n`},
+	})
+}
+
+func TestGroupPrefix(t *testing.T) {
+	addr, s := newTestServer(t)
+	runScript(t, addr, []step{
+		{"get names:Tom\r\n", `VALUE names:Tom 0 8\r\nname:Tom\r\nEND\r\n`},
+		// 分组不存在时整个 key 属于默认分组
+		{"set user:1 0 0 1\r\nx\r\n", `STORED\r\n`},
+		{"get user:1\r\n", `VALUE user:1 0 1\r\nx\r\nEND\r\n`},
+	})
+	if !s.Registry.GetGroup("scores").Exists("user:1") {
+		t.Fatalf("user:1 should be stored in the default group")
+	}
+	if s.Registry.GetGroup("names").Exists("user:1") {
+		t.Fatalf("names should not receive keys of other prefixes")
+	}
+}
+
+func TestGetsAndCAS(t *testing.T) {
+	addr, _ := newTestServer(t)
+	m := runScript(t, addr, []step{
+		{"set k 0 0 2\r\nv1\r\n", `STORED\r\n`},
+		{"gets k\r\n", `VALUE k 0 2 (\d+)\r\nv1\r\nEND\r\n`},
+	})
+	cas := m[1][1]
+	runScript(t, addr, []step{
+		{"cas k 0 0 2 " + cas + "\r\nv2\r\n", `STORED\r\n`},
+		// 值已经被修改, 旧的 CAS 值失效
+		{"cas k 0 0 2 " + cas + "\r\nv3\r\n", `EXISTS\r\n`},
+		{"cas missing 0 0 2 " + cas + "\r\nv3\r\n", `NOT_FOUND\r\n`},
+		{"get k\r\n", `VALUE k 0 2\r\nv2\r\nEND\r\n`},
+	})
+}
+
+func TestMetaCommands(t *testing.T) {
+	addr, _ := newTestServer(t)
+	m := runScript(t, addr, []step{
+		{"mg Tom v\r\n", `VA 3\r\n630\r\n`},
+		{"mg Tom k s t f Oabc\r\n", `HD kTom s3 t-1 f0 Oabc\r\n`},
+		{"mg unknown v\r\n", `EN\r\n`},
+		{"mg unknown v q\r\nmn\r\n", `MN\r\n`},
+		{"ms k 2 T100\r\nv1\r\n", `HD\r\n`},
+		{"mg k v c t\r\n", `VA 2 c(\d+) t100\r\nv1\r\n`},
+		{"ms k 2 ME\r\nxx\r\n", `NS\r\n`},
+		{"ms new 2 ME c\r\nxx\r\n", `HD c\d+\r\n`},
+		{"ms missing 2 MR\r\nxx\r\n", `NS\r\n`},
+		{"md k q\r\nmd k\r\n", `NF\r\n`},
+		{"md new k O1\r\n", `HD knew O1\r\n`},
+		{"ms bad 2 MA\r\nxx\r\n", `CLIENT_ERROR invalid mode for ms\r\n`},
+	})
+	cas := m[5][1]
+	m = runScript(t, addr, []step{
+		{"ms k 2\r\nv1\r\nmg k c\r\n", `HD\r\nHD c(\d+)\r\n`},
+	})
+	current := m[0][1]
+	runScript(t, addr, []step{
+		{"ms k 2 C" + cas + "\r\nv2\r\n", `EX\r\n`},
+		{"ms k 2 C" + current + " q\r\nv3\r\nmn\r\n", `MN\r\n`},
+		{"mg k v\r\n", `VA 2\r\nv3\r\n`},
+	})
+}
+
+func TestBadDataChunkClosesConnection(t *testing.T) {
+	addr, _ := newTestServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "set k 0 0 1\r\ntoolong\r\n")
+	got, _ := ioutil.ReadAll(conn)
+	if string(got) != "CLIENT_ERROR bad data chunk\r\n" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	addr, _ := newTestServer(t)
+	runScript(t, addr, []step{
+		{"get Tom unknown\r\n", `VALUE Tom 0 3\r\n630\r\nEND\r\n`},
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "stats\r\n")
+	r := bufio.NewReader(conn)
+	stats := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "END\r\n" {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			t.Fatalf("unexpected stats line %q", line)
+		}
+		stats[fields[1]] = fields[2]
+	}
+	if stats["cmd_get"] != "2" || stats["get_hits"] != "1" || stats["get_misses"] != "1" || stats["total_connections"] != "2" {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+}
+
+// 把所有 key 交给另一个进程内的分组负责
+type ownerPeer struct {
+	group *ruoCache.Group
+}
+
+func (p ownerPeer) PickPeer(string) (ruoCache.PeerGetter, bool) { return p, true }
+
+func (p ownerPeer) Get(*pb.Request, *pb.Response) error { return errors.New("not implemented") }
+
+func (p ownerPeer) Set(group, key string, value []byte, ttl time.Duration) error {
+	return p.group.SetOnOwner(key, string(value), ttl)
+}
+
+func (p ownerPeer) CompareAndSet(group, key string, expected uint64, value []byte, ttl time.Duration) (uint64, error) {
+	return p.group.CompareAndSetOnOwner(key, expected, string(value), ttl)
+}
+
+func (p ownerPeer) Version(group, key string) (uint64, bool, error) {
+	return p.group.VersionOnOwner(key)
+}
+
+func (p ownerPeer) Delete(group, key string) (bool, error) {
+	return p.group.DeleteOnOwner(key)
+}
+
+// 集群中所有写入都交给 key 的负责节点, replace 和 cas 使用负责节点上的版本号
+func TestWritesGoToOwner(t *testing.T) {
+	addr, s := newTestServer(t)
+	owner := ruoCache.NewRegistry().NewGroup("scores", 2<<10, ruoCache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, ruoCache.ErrNotFound
+	}))
+	local := s.Registry.GetGroup("scores")
+	local.RegisterPeers(ownerPeer{owner})
+
+	runScript(t, addr, []step{
+		{"set a 0 0 1\r\n1\r\n", `STORED\r\n`},
+		{"add a 0 0 1\r\n2\r\n", `NOT_STORED\r\n`},
+		{"replace b 0 0 1\r\n2\r\n", `NOT_STORED\r\n`},
+		{"replace a 0 0 1\r\n2\r\n", `STORED\r\n`},
+		{"cas a 0 0 1 1\r\n3\r\n", `EXISTS\r\n`},
+		{"cas b 0 0 1 1\r\n3\r\n", `NOT_FOUND\r\n`},
+		{"ms c 1 MR\r\n3\r\n", `NS\r\n`},
+		{"ms c 1 C1\r\n3\r\n", `NF\r\n`},
+		{"set gone 0 -1 1\r\n3\r\n", `STORED\r\n`},
+	})
+	if v, err := owner.Get("a"); err != nil || v.String() != "2" {
+		t.Fatalf("owner should store the replaced value, got %q %v", v, err)
+	}
+	if local.Exists("a") || owner.Exists("gone") {
+		t.Fatalf("writes should only reach the owner")
+	}
+
+	version, _ := owner.Version("a")
+	cas := strconv.FormatUint(version, 10)
+	runScript(t, addr, []step{
+		{"cas a 0 0 1 " + cas + "\r\n4\r\n", `STORED\r\n`},
+		{"ms a 1 MR\r\n5\r\n", `HD\r\n`},
+		{"delete a\r\n", `DELETED\r\n`},
+		{"md a\r\n", `NF\r\n`},
+	})
+	if owner.Exists("a") {
+		t.Fatalf("owner should no longer cache a")
+	}
+}
@@ -0,0 +1,277 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"ruoCache"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// get <key>*, gets 同时返回 CAS 值
+func (sess *session) get(keys []string, withCAS bool) {
+	if len(keys) == 0 {
+		sess.reply("ERROR")
+		return
+	}
+	for _, key := range keys {
+		if err := checkKey(key); err != nil {
+			sess.clientError(err)
+			return
+		}
+	}
+	for _, key := range keys {
+		v, ok, err := sess.lookup(key)
+		if err != nil {
+			sess.serverError(err)
+			return
+		}
+		if !ok {
+			continue
+		}
+		if withCAS {
+			fmt.Fprintf(sess.w, "VALUE %s 0 %d %d\r\n", key, v.Len(), v.Version())
+		} else {
+			fmt.Fprintf(sess.w, "VALUE %s 0 %d\r\n", key, v.Len())
+		}
+		v.WriteTo(sess.w)
+		sess.reply("")
+	}
+	sess.reply("END")
+}
+
+// 最后一个参数是 noreply 时不回复
+func isNoreply(args []string) bool {
+	return len(args) > 0 && args[len(args)-1] == "noreply"
+}
+
+func (sess *session) noreply(args []string, msg string) {
+	if !isNoreply(args) {
+		sess.reply(msg)
+	}
+}
+
+// <set|add|replace> <key> <flags> <exptime> <bytes> [noreply]
+// cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (sess *session) store(name string, args []string) error {
+	n := 4
+	if name == "cas" {
+		n = 5
+	}
+	if len(args) != n && !(len(args) == n+1 && isNoreply(args)) {
+		sess.reply("ERROR")
+		return nil
+	}
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 {
+		return clientError("bad data chunk")
+	}
+	if size > maxItemBytes {
+		// 丢弃数据块, 连接可以继续使用
+		if _, err := io.CopyN(ioutil.Discard, sess.r, int64(size)+2); err != nil {
+			return err
+		}
+		sess.reply("SERVER_ERROR object too large for cache")
+		return nil
+	}
+	data, err := sess.readData(size)
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&sess.server.stats.cmdSet, 1)
+
+	key := args[0]
+	if err := checkKey(key); err != nil {
+		sess.clientError(err)
+		return nil
+	}
+	_, err = strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		sess.clientError(clientError("bad command line format"))
+		return nil
+	}
+	ttl, err := parseExptime(args[2])
+	if err != nil {
+		sess.clientError(err)
+		return nil
+	}
+	var cas uint64
+	if name == "cas" {
+		if cas, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+			sess.clientError(clientError("bad command line format"))
+			return nil
+		}
+	}
+	g, k := sess.server.route(key)
+	if g == nil {
+		sess.serverError(errors.New("no such group"))
+		return nil
+	}
+
+	stored := true
+	switch name {
+	case "set":
+		err = g.SetOnOwner(k, string(data), ttl)
+	case "add":
+		// 版本号 0 表示 key 必须不存在
+		_, err = g.CompareAndSetOnOwner(k, 0, string(data), ttl)
+		if errors.Is(err, ruoCache.ErrVersionMismatch) {
+			stored, err = false, nil
+		}
+	case "replace":
+		stored, _, err = replace(g, k, string(data), ttl)
+	case "cas":
+		_, err = compareAndSet(g, k, cas, string(data), ttl)
+		if errors.Is(err, errNotCached) {
+			sess.noreply(args, "NOT_FOUND")
+			return nil
+		}
+		if errors.Is(err, ruoCache.ErrVersionMismatch) {
+			sess.noreply(args, "EXISTS")
+			return nil
+		}
+	}
+	if err == nil && stored {
+		err = expireNow(g, k, ttl)
+	}
+	switch {
+	case err != nil:
+		sess.serverError(err)
+	case !stored:
+		sess.noreply(args, "NOT_STORED")
+	default:
+		sess.noreply(args, "STORED")
+	}
+	return nil
+}
+
+// cas 时 key 没有缓存
+var errNotCached = errors.New("not cached")
+
+// 读到的版本号为 cas 时用它写入负责节点, 不是先判断 key 是否存在再写入, 两者之间的写入不会被覆盖。
+// key 没有缓存时返回 errNotCached, 版本号不一致时返回 ruoCache.ErrVersionMismatch
+func compareAndSet(g *ruoCache.Group, key string, cas uint64, value string, ttl time.Duration) (uint64, error) {
+	version, ok, err := g.VersionOnOwner(key)
+	switch {
+	case err != nil:
+		return 0, err
+	case !ok:
+		return 0, errNotCached
+	case version != cas:
+		return 0, ruoCache.ErrVersionMismatch
+	}
+	return g.CompareAndSetOnOwner(key, version, value, ttl)
+}
+
+// key 已经缓存时才写入, 用读到的版本号写入, 期间有其他写入时重新读取
+func replace(g *ruoCache.Group, key, value string, ttl time.Duration) (bool, uint64, error) {
+	for {
+		version, ok, err := g.VersionOnOwner(key)
+		if err != nil || !ok {
+			return false, 0, err
+		}
+		version, err = g.CompareAndSetOnOwner(key, version, value, ttl)
+		if !errors.Is(err, ruoCache.ErrVersionMismatch) {
+			return err == nil, version, err
+		}
+	}
+}
+
+// delete <key> [noreply]
+func (sess *session) delete(args []string) {
+	if len(args) != 1 && !(len(args) == 2 && isNoreply(args)) {
+		sess.reply("ERROR")
+		return
+	}
+	if err := checkKey(args[0]); err != nil {
+		sess.clientError(err)
+		return
+	}
+	g, k := sess.server.route(args[0])
+	if g == nil {
+		sess.serverError(errors.New("no such group"))
+		return
+	}
+	existed, err := g.DeleteOnOwner(k)
+	if err != nil {
+		sess.serverError(err)
+		return
+	}
+	if existed {
+		sess.noreply(args, "DELETED")
+	} else {
+		sess.noreply(args, "NOT_FOUND")
+	}
+}
+
+// touch <key> <exptime> [noreply]
+func (sess *session) touch(args []string) {
+	if len(args) != 2 && !(len(args) == 3 && isNoreply(args)) {
+		sess.reply("ERROR")
+		return
+	}
+	atomic.AddUint64(&sess.server.stats.cmdTouch, 1)
+	if err := checkKey(args[0]); err != nil {
+		sess.clientError(err)
+		return
+	}
+	ttl, err := parseExptime(args[1])
+	if err != nil {
+		sess.clientError(err)
+		return
+	}
+	g, k := sess.server.route(args[0])
+	if g == nil {
+		sess.serverError(errors.New("no such group"))
+		return
+	}
+	if !g.Exists(k) {
+		sess.noreply(args, "NOT_FOUND")
+		return
+	}
+	touched := g.Persist(k)
+	if ttl != 0 {
+		touched = g.Expire(k, ttl)
+	}
+	if touched {
+		sess.noreply(args, "TOUCHED")
+	} else {
+		sess.noreply(args, "NOT_FOUND")
+	}
+}
+
+// stats, 不支持子命令
+func (sess *session) stats(args []string) {
+	if len(args) > 0 {
+		sess.reply("END")
+		return
+	}
+	s := sess.server
+	s.mutex.Lock()
+	started := s.started
+	s.mutex.Unlock()
+	stat := func(name string, value interface{}) {
+		fmt.Fprintf(sess.w, "STAT %s %v\r\n", name, value)
+	}
+	stat("pid", os.Getpid())
+	stat("uptime", int64(time.Since(started)/time.Second))
+	stat("time", time.Now().Unix())
+	stat("version", "ruocache")
+	stat("curr_connections", atomic.LoadInt64(&s.stats.currConns))
+	stat("total_connections", atomic.LoadUint64(&s.stats.totalConns))
+	stat("cmd_get", atomic.LoadUint64(&s.stats.cmdGet))
+	stat("cmd_set", atomic.LoadUint64(&s.stats.cmdSet))
+	stat("cmd_touch", atomic.LoadUint64(&s.stats.cmdTouch))
+	stat("get_hits", atomic.LoadUint64(&s.stats.getHits))
+	stat("get_misses", atomic.LoadUint64(&s.stats.getMisses))
+	if g := s.registry().GetGroup(s.Group); g != nil {
+		loads := g.LoadStats()
+		stat("loads", loads.Loads)
+		stat("loads_rejected", loads.Rejected)
+	}
+	sess.reply("END")
+}
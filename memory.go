@@ -0,0 +1,143 @@
+// 每个分组有自己的 cacheBytes, 但分组的数量没有限制, 按需创建分组时进程可能 OOM。
+// 每个 Registry 的内存管理器给其中所有分组设置一个共享的上限: 分组的 cacheBytes
+// 是它最多能用的内存, minBytes 是保证给它的内存。总量超过上限时, 从单位内存命中次数最低的分组开始淘汰,
+// 命中次数定期减半, 反映的是最近的访问情况。
+package ruoCache
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const memoryDecayInterval = 10 * time.Second
+
+type memoryManager struct {
+	budget int64 // 0 表示不限制, 原子读写
+	usage  int64 // 所有分组的缓存占用的内存, 由 cache.account 原子更新
+
+	mutex     sync.Mutex // 保护 groups 和 lastDecay, 同一时间只有一个协程淘汰缓存
+	groups    map[*Group]struct{}
+	lastDecay time.Time
+}
+
+func newMemoryManager() *memoryManager {
+	return &memoryManager{
+		groups:    make(map[*Group]struct{}),
+		lastDecay: time.Now(),
+	}
+}
+
+// 设置 DefaultRegistry 中所有分组共享的内存上限, 0 表示不限制
+func SetMemoryBudget(bytes int64) {
+	DefaultRegistry.SetMemoryBudget(bytes)
+}
+
+// 设置全局内存不足时至少为分组保留的内存
+func (g *Group) SetMinBytes(bytes int64) {
+	atomic.StoreInt64(&g.minBytes, bytes)
+}
+
+func (m *memoryManager) setBudget(bytes int64) {
+	atomic.StoreInt64(&m.budget, bytes)
+	m.enforce()
+}
+
+// 在分组被其他协程使用之前调用
+func (m *memoryManager) register(g *Group) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.groups[g] = struct{}{}
+	g.memory = m
+	g.mainCache.onAdd = m.added
+	g.hotCache.onAdd = m.added
+	g.mainCache.trackUsage(&m.usage)
+	g.hotCache.trackUsage(&m.usage)
+}
+
+func (m *memoryManager) unregister(g *Group) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.groups, g)
+	g.memory = nil
+	g.mainCache.trackUsage(nil)
+	g.hotCache.trackUsage(nil)
+}
+
+// 添加缓存之后调用, 总量没有超过上限时只读两个原子变量, 不加锁
+func (m *memoryManager) added() {
+	if budget := atomic.LoadInt64(&m.budget); budget > 0 && atomic.LoadInt64(&m.usage) > budget {
+		m.enforce()
+	}
+}
+
+// 总量超过上限时淘汰缓存, 直到不超过上限或者所有分组都只剩下保留的内存。
+// 先淘汰分组的 mainCache, 没有可以淘汰的再淘汰热点副本
+func (m *memoryManager) enforce() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	budget := atomic.LoadInt64(&m.budget)
+	if budget <= 0 || atomic.LoadInt64(&m.usage) <= budget {
+		return
+	}
+	m.decay(time.Now())
+
+	usage := make(map[*Group]int64, len(m.groups))
+	for g := range m.groups {
+		usage[g] = g.mainCache.bytes() + g.hotCache.bytes()
+	}
+	for atomic.LoadInt64(&m.usage) > budget {
+		victim := m.victim(usage)
+		if victim == nil {
+			log.Printf("[RuoCache] memory budget %d exceeded, %d bytes in use are all reserved", budget, atomic.LoadInt64(&m.usage))
+			return
+		}
+		freed := victim.mainCache.removeOldest()
+		if freed == 0 {
+			freed = victim.hotCache.removeOldest()
+		}
+		if freed == 0 {
+			delete(usage, victim) // 已经清空, 不再考虑
+			continue
+		}
+		usage[victim] -= freed
+	}
+}
+
+// 在超过保留内存的分组中选出单位内存命中次数最低的, 调用方需要持有锁
+func (m *memoryManager) victim(usage map[*Group]int64) *Group {
+	var victim *Group
+	var lowest float64
+	for g, used := range usage {
+		if used <= atomic.LoadInt64(&g.minBytes) {
+			continue
+		}
+		score := float64(atomic.LoadUint64(&g.hits)) / float64(used)
+		if victim == nil || score < lowest || (score == lowest && g.name < victim.name) {
+			victim, lowest = g, score
+		}
+	}
+	return victim
+}
+
+// 每经过一个周期命中次数减半, 调用方需要持有锁
+func (m *memoryManager) decay(now time.Time) {
+	n := now.Sub(m.lastDecay) / memoryDecayInterval
+	if n <= 0 {
+		return
+	}
+	m.lastDecay = m.lastDecay.Add(n * memoryDecayInterval)
+	shift := uint(64)
+	if n < 64 {
+		shift = uint(n)
+	}
+	for g := range m.groups {
+		for {
+			hits := atomic.LoadUint64(&g.hits)
+			if atomic.CompareAndSwapUint64(&g.hits, hits, hits>>shift) {
+				break
+			}
+		}
+	}
+}
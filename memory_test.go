@@ -0,0 +1,96 @@
+package ruoCache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	m := newMemoryManager()
+	newTestGroup := func(name string) *Group {
+		g := newGroup(name, 1<<20, GetterFunc(func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+		m.register(g)
+		return g
+	}
+	hot, cold, reserved := newTestGroup("hot"), newTestGroup("cold"), newTestGroup("reserved")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		hot.Set(key, "value")
+		cold.Set(key, "value")
+		reserved.Set(key, "value")
+		hot.Get(key)
+	}
+	used := hot.mainCache.bytes()
+	reserved.SetMinBytes(used / 2)
+
+	// 只能放下大约一个分组的缓存
+	m.setBudget(used * 3 / 2)
+	total := hot.mainCache.bytes() + cold.mainCache.bytes() + reserved.mainCache.bytes()
+	if total > used*3/2 {
+		t.Fatalf("total %d exceeds the budget %d", total, used*3/2)
+	}
+	if hot.mainCache.bytes() != used {
+		t.Fatalf("the group with the most hits should not be evicted")
+	}
+	if reserved.mainCache.bytes() < used/2 {
+		t.Fatalf("reserved group was evicted below its minimum: %d < %d", reserved.mainCache.bytes(), used/2)
+	}
+	if cold.mainCache.bytes() != 0 {
+		t.Fatalf("the cold group should be evicted first, %d bytes left", cold.mainCache.bytes())
+	}
+
+	// 之后的写入同样受限制
+	cold.Set("new", "value")
+	total = hot.mainCache.bytes() + cold.mainCache.bytes() + reserved.mainCache.bytes()
+	if total > used*3/2 {
+		t.Fatalf("total %d exceeds the budget %d after set", total, used*3/2)
+	}
+}
+
+// 只剩下热点副本的分组也可以被淘汰
+func TestMemoryBudgetEvictsHotCache(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGroup("replicas", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	for i := 0; i < 100; i++ {
+		g.hotCache.add(fmt.Sprintf("key%03d", i), ByteView{b: []byte("value")})
+	}
+	used := g.hotCache.bytes()
+	if r.memory.usage != used {
+		t.Fatalf("usage %d does not match the hot cache %d", r.memory.usage, used)
+	}
+	r.SetMemoryBudget(used / 2)
+	if g.hotCache.bytes() > used/2 {
+		t.Fatalf("hot cache was not evicted: %d > %d", g.hotCache.bytes(), used/2)
+	}
+}
+
+// 占用的内存随写入、删除和关闭分组更新
+func TestMemoryUsageAccounting(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	})
+	a, b := r.NewGroup("a", 1<<20, getter), r.NewGroup("b", 1<<20, getter)
+	a.Set("tom", "630")
+	b.Set("tom", "630")
+	b.Set("jack", "589")
+	if total := a.mainCache.bytes() + b.mainCache.bytes(); r.memory.usage != total || total == 0 {
+		t.Fatalf("usage %d, expect %d", r.memory.usage, total)
+	}
+	b.Delete("jack")
+	if r.memory.usage != a.mainCache.bytes()+b.mainCache.bytes() {
+		t.Fatalf("usage was not updated after delete")
+	}
+	r.DeleteGroup("b")
+	if r.memory.usage != a.mainCache.bytes() {
+		t.Fatalf("usage %d should only include group a %d", r.memory.usage, a.mainCache.bytes())
+	}
+	a.Close()
+	if r.memory.usage != 0 {
+		t.Fatalf("usage should be 0 after all groups are closed, got %d", r.memory.usage)
+	}
+}
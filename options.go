@@ -0,0 +1,308 @@
+// 分组的配置项。新的配置只需要增加一个 GroupOption, 不影响已有的调用方。
+// 配置在 NewGroupWithOptions 中统一校验, 不合法时返回错误而不是创建出一个半可用的分组。
+package ruoCache
+
+import (
+	"crypto/aes"
+	"errors"
+	"fmt"
+	"log"
+	"ruoCache/lru"
+	"ruoCache/singleflight"
+	"strings"
+	"time"
+)
+
+// 分组的配置项
+type GroupOption func(o *groupOptions) error
+
+type groupOptions struct {
+	cacheBytes    int64
+	hotCacheBytes int64 // 小于 0 表示使用 cacheBytes/8
+	ttl           time.Duration
+	minBytes      int64
+	hotThreshold  uint64
+	hotLease      time.Duration
+	setter        Setter
+	mode          WriteMode
+	peers         PeerPicker
+	chunkSize     int
+	compressor    Compressor
+	keys          KeyProvider
+
+	loadConcurrency int
+	loadRate        float64
+	loadBurst       int
+	loadWait        time.Duration
+
+	eviction EvictionPolicy
+	logger   Logger
+	stats    StatsHooks
+}
+
+func defaultGroupOptions() groupOptions {
+	return groupOptions{
+		hotCacheBytes: -1,
+		hotThreshold:  defaultHotThreshold,
+		hotLease:      defaultHotLease,
+		chunkSize:     defaultChunkSize,
+		logger:        log.Default(),
+	}
+}
+
+// mainCache 的大小, 0 表示不限制
+func WithCacheBytes(bytes int64) GroupOption {
+	return func(o *groupOptions) error {
+		if bytes < 0 {
+			return fmt.Errorf("cache bytes must not be negative: %d", bytes)
+		}
+		o.cacheBytes = bytes
+		return nil
+	}
+}
+
+// hotCache 的大小, 默认为 mainCache 的 1/8
+func WithHotCacheBytes(bytes int64) GroupOption {
+	return func(o *groupOptions) error {
+		if bytes < 0 {
+			return fmt.Errorf("hot cache bytes must not be negative: %d", bytes)
+		}
+		o.hotCacheBytes = bytes
+		return nil
+	}
+}
+
+// 写入 mainCache 的缓存在 ttl 之后过期, 0 表示永不过期
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(o *groupOptions) error {
+		if ttl < 0 {
+			return fmt.Errorf("ttl must not be negative: %v", ttl)
+		}
+		o.ttl = ttl
+		return nil
+	}
+}
+
+// 全局内存不足时至少保留的内存, 见 Group.SetMinBytes
+func WithMinBytes(bytes int64) GroupOption {
+	return func(o *groupOptions) error {
+		if bytes < 0 {
+			return fmt.Errorf("min bytes must not be negative: %d", bytes)
+		}
+		o.minBytes = bytes
+		return nil
+	}
+}
+
+// 热点 key 的阈值和副本的租期, 见 Group.SetHotKeyPolicy
+func WithHotKeyPolicy(threshold uint64, lease time.Duration) GroupOption {
+	return func(o *groupOptions) error {
+		if threshold > 0 && lease <= 0 {
+			return fmt.Errorf("hot key lease must be positive: %v", lease)
+		}
+		o.hotThreshold = threshold
+		o.hotLease = lease
+		return nil
+	}
+}
+
+// 注册数据源, 见 Group.RegisterStore
+func WithStore(setter Setter, mode WriteMode) GroupOption {
+	return func(o *groupOptions) error {
+		if setter == nil {
+			return errors.New("nil setter")
+		}
+		if mode != WriteThrough && mode != WriteBehind {
+			return fmt.Errorf("unknown write mode: %d", mode)
+		}
+		o.setter = setter
+		o.mode = mode
+		return nil
+	}
+}
+
+// 超过 bytes 的 value 分块保存
+func WithChunkSize(bytes int) GroupOption {
+	return func(o *groupOptions) error {
+		if bytes <= 0 {
+			return fmt.Errorf("chunk size must be positive: %d", bytes)
+		}
+		o.chunkSize = bytes
+		return nil
+	}
+}
+
+// 写入 mainCache 时使用 c 压缩, 读取时解压
+func WithCompression(c Compressor) GroupOption {
+	return func(o *groupOptions) error {
+		if c == nil || c.Name() == "" {
+			return errors.New("compressor must have a name")
+		}
+		o.compressor = c
+		return nil
+	}
+}
+
+// 写入 mainCache 时使用 AES-GCM 加密, 密钥由 keys 提供
+func WithEncryption(keys KeyProvider) GroupOption {
+	return func(o *groupOptions) error {
+		if keys == nil {
+			return errors.New("nil key provider")
+		}
+		_, key, err := keys.CurrentKey()
+		if err != nil {
+			return err
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return err
+		}
+		o.keys = keys
+		return nil
+	}
+}
+
+// 最多同时调用 n 次 Getter
+func WithLoadConcurrency(n int) GroupOption {
+	return func(o *groupOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("load concurrency must be positive: %d", n)
+		}
+		o.loadConcurrency = n
+		return nil
+	}
+}
+
+// 每秒最多调用 rate 次 Getter, 最多积攒 burst 次
+func WithLoadRate(rate float64, burst int) GroupOption {
+	return func(o *groupOptions) error {
+		if rate <= 0 || burst <= 0 {
+			return fmt.Errorf("load rate and burst must be positive: %v, %d", rate, burst)
+		}
+		o.loadRate = rate
+		o.loadBurst = burst
+		return nil
+	}
+}
+
+// 超出加载限制时最多等待 d, 之后返回 ErrOverloaded。默认不等待
+func WithLoadWait(d time.Duration) GroupOption {
+	return func(o *groupOptions) error {
+		if d < 0 {
+			return fmt.Errorf("load wait must not be negative: %v", d)
+		}
+		o.loadWait = d
+		return nil
+	}
+}
+
+// mainCache 的淘汰策略, 默认为 EvictLRU
+func WithEvictionPolicy(p EvictionPolicy) GroupOption {
+	return func(o *groupOptions) error {
+		if p != EvictLRU && p != EvictFIFO {
+			return fmt.Errorf("unknown eviction policy: %d", p)
+		}
+		o.eviction = p
+		return nil
+	}
+}
+
+// 分组的日志, 默认使用标准库的 log。log.New(ioutil.Discard, "", 0) 可以关闭日志
+func WithLogger(l Logger) GroupOption {
+	return func(o *groupOptions) error {
+		if l == nil {
+			return errors.New("nil logger")
+		}
+		o.logger = l
+		return nil
+	}
+}
+
+// 注册命中、未命中和加载的回调
+func WithStatsHooks(h StatsHooks) GroupOption {
+	return func(o *groupOptions) error {
+		if h.empty() {
+			return errors.New("stats hooks are all nil")
+		}
+		o.stats = h
+		return nil
+	}
+}
+
+// 注册节点选择器, 见 Group.RegisterPeers
+func WithPeers(peers PeerPicker) GroupOption {
+	return func(o *groupOptions) error {
+		if peers == nil {
+			return errors.New("nil peers")
+		}
+		o.peers = peers
+		return nil
+	}
+}
+
+// 按配置新建一个分组的实例, 名称已经存在时返回 ErrGroupExists
+func NewGroupWithOptions(name string, getter Getter, opts ...GroupOption) (*Group, error) {
+	return DefaultRegistry.NewGroupWithOptions(name, getter, opts...)
+}
+
+// 按配置新建分组但不注册到 Registry
+func buildGroup(name string, getter Getter, opts ...GroupOption) (*Group, error) {
+	if name == "" {
+		return nil, errors.New("group name is required")
+	}
+	// _handoff、_hot 等是节点之间通信的路径, 同名的分组无法访问
+	if strings.HasPrefix(name, "_") {
+		return nil, fmt.Errorf("group name must not start with '_': %s", name)
+	}
+	if getter == nil {
+		return nil, errors.New("nil getter")
+	}
+	o := defaultGroupOptions()
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, fmt.Errorf("group %s: %w", name, err)
+		}
+	}
+	if o.hotCacheBytes < 0 {
+		o.hotCacheBytes = o.cacheBytes / 8
+	}
+
+	g := &Group{
+		name:   name,
+		getter: getter,
+		mainCache: cache{
+			cacheBytes:    o.cacheBytes,
+			entryOverhead: lru.DefaultEntryOverhead,
+			policy:        lru.Policy(o.eviction),
+			logger:        o.logger,
+		},
+		hotCache: cache{
+			cacheBytes:    o.hotCacheBytes,
+			entryOverhead: lru.DefaultEntryOverhead,
+			logger:        o.logger,
+		},
+		peers:      o.peers,
+		ttl:        o.ttl,
+		chunkSize:  o.chunkSize,
+		compressor: o.compressor,
+		minBytes:   o.minBytes,
+		loader:     &singleflight.Group{},
+		limiter:    newLoadLimiter(o.loadConcurrency, o.loadRate, o.loadBurst, o.loadWait),
+		hotKeys:    newHotKeys(),
+		watchers:   newWatchHub(o.logger),
+		logger:     o.logger,
+		stats:      o.stats,
+	}
+	if o.cacheBytes > 0 {
+		g.hotRatio = float64(o.hotCacheBytes) / float64(o.cacheBytes)
+	}
+	if o.keys != nil {
+		g.envelope = newEnvelope(name, o.keys)
+	}
+	g.hotKeys.threshold = o.hotThreshold
+	g.hotKeys.lease = o.hotLease
+	g.mainCache.notify = g.watchers.publish
+	if o.setter != nil {
+		g.RegisterStore(o.setter, o.mode)
+	}
+	return g, nil
+}
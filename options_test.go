@@ -0,0 +1,159 @@
+package ruoCache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewGroupWithOptions(t *testing.T) {
+	s := &memStore{data: make(map[string]string)}
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	})
+	g, err := NewGroupWithOptions("options", getter,
+		WithCacheBytes(4<<10),
+		WithHotCacheBytes(1<<10),
+		WithTTL(50*time.Millisecond),
+		WithMinBytes(512),
+		WithHotKeyPolicy(0, 0),
+		WithStore(s, WriteThrough),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteGroup("options")
+
+	if g.mainCache.cacheBytes != 4<<10 || g.hotCache.cacheBytes != 1<<10 || g.minBytes != 512 {
+		t.Fatalf("options were not applied")
+	}
+	if err := g.Set("tom", "630"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.get("tom"); v != "630" {
+		t.Fatalf("store was not registered")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := g.mainCache.get("tom"); ok {
+		t.Fatalf("entry should expire after the group ttl")
+	}
+
+	if _, err := NewGroupWithOptions("options", getter); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expect ErrGroupExists, got %v", err)
+	}
+}
+
+func TestGroupOptionsValidation(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	})
+	tests := []struct {
+		name   string
+		getter Getter
+		opts   []GroupOption
+	}{
+		{"", getter, nil},
+		{"_hot", getter, nil},
+		{"invalid", nil, nil},
+		{"invalid", getter, []GroupOption{WithCacheBytes(-1)}},
+		{"invalid", getter, []GroupOption{WithHotCacheBytes(-1)}},
+		{"invalid", getter, []GroupOption{WithTTL(-time.Second)}},
+		{"invalid", getter, []GroupOption{WithMinBytes(-1)}},
+		{"invalid", getter, []GroupOption{WithHotKeyPolicy(10, 0)}},
+		{"invalid", getter, []GroupOption{WithStore(nil, WriteThrough)}},
+		{"invalid", getter, []GroupOption{WithStore(&memStore{}, WriteMode(9))}},
+		{"invalid", getter, []GroupOption{WithPeers(nil)}},
+		{"invalid", getter, []GroupOption{WithChunkSize(0)}},
+		{"invalid", getter, []GroupOption{WithCompression(nil)}},
+		{"invalid", getter, []GroupOption{WithEncryption(nil)}},
+		{"invalid", getter, []GroupOption{WithLoadConcurrency(0)}},
+		{"invalid", getter, []GroupOption{WithLoadRate(10, 0)}},
+		{"invalid", getter, []GroupOption{WithLoadWait(-time.Second)}},
+		{"invalid", getter, []GroupOption{WithEvictionPolicy(EvictionPolicy(9))}},
+		{"invalid", getter, []GroupOption{WithLogger(nil)}},
+		{"invalid", getter, []GroupOption{WithStatsHooks(StatsHooks{})}},
+	}
+	for i, tt := range tests {
+		if g, err := NewGroupWithOptions(tt.name, tt.getter, tt.opts...); err == nil || g != nil {
+			t.Errorf("case %d: expect an error", i)
+		}
+	}
+	if GetGroup("invalid") != nil {
+		t.Fatalf("invalid group should not be registered")
+	}
+}
+
+func TestLoggerAndStatsHooks(t *testing.T) {
+	var buf bytes.Buffer
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	record := func(name string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		counts[name]++
+	}
+	r := NewRegistry()
+	g, err := r.NewGroupWithOptions("hooks", GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte("value of " + key), nil
+	}),
+		WithCacheBytes(4<<10),
+		WithLogger(log.New(&buf, "", 0)),
+		WithStatsHooks(StatsHooks{
+			OnHit:  func(group string, hot bool) { record(fmt.Sprintf("hit %s %v", group, hot)) },
+			OnMiss: func(group string) { record("miss " + group) },
+			OnLoad: func(group string, fromPeer bool, d time.Duration, err error) {
+				record(fmt.Sprintf("load %s %v %v", group, fromPeer, err != nil))
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Get("tom")
+	g.Get("tom")
+	g.Get("missing")
+
+	want := map[string]int{
+		"hit hooks false":        1,
+		"miss hooks":             2,
+		"load hooks false false": 1,
+		"load hooks false true":  1,
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", counts, want)
+	}
+	if !strings.Contains(buf.String(), "new Group hooks") || !strings.Contains(buf.String(), "add cache key tom") {
+		t.Fatalf("group logs should go to the configured logger:\n%s", buf.String())
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	r := NewRegistry()
+	g, err := r.NewGroupWithOptions("fifo", GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	}), WithCacheBytes(1<<10), WithEvictionPolicy(EvictFIFO))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Set("first", "value")
+	for i := 0; g.Exists("first"); i++ {
+		g.Get("first") // FIFO 下访问不会推迟淘汰
+		g.Set(fmt.Sprintf("key%d", i), "value")
+		if i > 100 {
+			t.Fatalf("first should be evicted")
+		}
+	}
+	if !g.Exists("key0") {
+		t.Fatalf("later keys should be kept")
+	}
+}
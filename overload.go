@@ -0,0 +1,105 @@
+// singleflight 只能合并相同 key 的加载, 大量不同的 key 同时未命中时都会落到 Getter 上。
+// 分组可以限制同时进行的加载数量和每秒的加载次数, 超出限制的加载最多等待 maxWait,
+// 之后返回 ErrOverloaded, 被拒绝的次数记录在 LoadStats 中。
+package ruoCache
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// 加载超出了分组的限制
+var ErrOverloaded = errors.New("group overloaded")
+
+// 分组从 Getter 加载的统计
+type LoadStats struct {
+	Loads    uint64 // 调用 Getter 的次数
+	Rejected uint64 // 因为超出限制被拒绝的次数
+	InFlight int64  // 正在进行的加载
+}
+
+type loadLimiter struct {
+	slots   chan struct{} // 为空时不限制并发
+	bucket  *tokenBucket  // 为空时不限制速率
+	maxWait time.Duration // 为 0 时超出限制立即失败
+
+	loads    uint64
+	rejected uint64
+	inFlight int64
+}
+
+func newLoadLimiter(concurrency int, rate float64, burst int, maxWait time.Duration) *loadLimiter {
+	l := &loadLimiter{maxWait: maxWait}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+	if rate > 0 {
+		l.bucket = newTokenBucket(rate, float64(burst))
+	}
+	return l
+}
+
+// 等待加载的许可, 成功后必须调用 release
+func (l *loadLimiter) acquire(group string) error {
+	deadline := time.Now().Add(l.maxWait)
+	if l.bucket != nil {
+		d, ok := l.bucket.reserveWithin(1, l.maxWait)
+		if !ok {
+			return l.reject(group, "rate limit exceeded")
+		}
+		time.Sleep(d)
+	}
+	if l.slots != nil && !l.acquireSlot(deadline) {
+		if l.bucket != nil {
+			l.bucket.refund(1) // 没有加载, 归还已经取走的令牌
+		}
+		return l.reject(group, "too many concurrent loads")
+	}
+	atomic.AddUint64(&l.loads, 1)
+	atomic.AddInt64(&l.inFlight, 1)
+	return nil
+}
+
+// 在 deadline 之前占用一个并发名额
+func (l *loadLimiter) acquireSlot(deadline time.Time) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (l *loadLimiter) release() {
+	atomic.AddInt64(&l.inFlight, -1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+func (l *loadLimiter) reject(group, reason string) error {
+	atomic.AddUint64(&l.rejected, 1)
+	return fmt.Errorf("%w: %s: %s", ErrOverloaded, group, reason)
+}
+
+// 返回从 Getter 加载的统计
+func (g *Group) LoadStats() LoadStats {
+	return LoadStats{
+		Loads:    atomic.LoadUint64(&g.limiter.loads),
+		Rejected: atomic.LoadUint64(&g.limiter.rejected),
+		InFlight: atomic.LoadInt64(&g.limiter.inFlight),
+	}
+}
@@ -0,0 +1,137 @@
+package ruoCache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadConcurrencyLimit(t *testing.T) {
+	for _, wait := range []time.Duration{0, time.Second} {
+		block := make(chan struct{})
+		g, err := buildGroup("limited", GetterFunc(func(key string) ([]byte, error) {
+			if key == "slow" {
+				<-block
+			}
+			return []byte(key), nil
+		}), WithLoadConcurrency(1), WithLoadWait(wait))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error)
+		go func() {
+			_, err := g.Get("slow")
+			done <- err
+		}()
+		for atomic.LoadInt64(&g.limiter.inFlight) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		if wait == 0 {
+			if _, err := g.Get("fast"); !errors.Is(err, ErrOverloaded) {
+				t.Fatalf("expect ErrOverloaded, got %v", err)
+			}
+			close(block)
+		} else {
+			time.AfterFunc(20*time.Millisecond, func() { close(block) })
+			if v, err := g.Get("fast"); err != nil || v.String() != "fast" {
+				t.Fatalf("expect the load to wait for a free slot, got %v", err)
+			}
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		stats := g.LoadStats()
+		want := LoadStats{Loads: 1, Rejected: 1}
+		if wait > 0 {
+			want = LoadStats{Loads: 2}
+		}
+		if stats != want {
+			t.Fatalf("wait %v: expect %+v, got %+v", wait, want, stats)
+		}
+	}
+}
+
+func TestLoadRateLimit(t *testing.T) {
+	g, err := buildGroup("rated", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithLoadRate(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := g.Get(key); err != nil {
+			t.Fatalf("burst should allow %s: %v", key, err)
+		}
+	}
+	if _, err := g.Get("c"); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expect ErrOverloaded, got %v", err)
+	}
+	if _, err := g.Get("a"); err != nil {
+		t.Fatalf("cache hits should not be limited: %v", err)
+	}
+	if stats := g.LoadStats(); stats.Loads != 2 || stats.Rejected != 1 {
+		t.Fatalf("got %+v", stats)
+	}
+}
+
+func TestGetterPanicReleasesSlot(t *testing.T) {
+	g, err := buildGroup("panicky", GetterFunc(func(key string) ([]byte, error) {
+		if key == "boom" {
+			panic("getter failed")
+		}
+		return []byte(key), nil
+	}), WithLoadConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expect the getter panic to propagate")
+			}
+		}()
+		g.Get("boom")
+	}()
+	if n := g.LoadStats().InFlight; n != 0 {
+		t.Fatalf("expect no loads in flight, got %d", n)
+	}
+	if v, err := g.Get("tom"); err != nil || v.String() != "tom" {
+		t.Fatalf("slot leaked after a panic: %v", err)
+	}
+}
+
+// 等不到并发名额时归还令牌, 不占用之后的加载速率
+func TestLoadRefundsToken(t *testing.T) {
+	block := make(chan struct{})
+	g, err := buildGroup("refund", GetterFunc(func(key string) ([]byte, error) {
+		if key == "slow" {
+			<-block
+		}
+		return []byte(key), nil
+	}), WithLoadConcurrency(1), WithLoadRate(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := g.Get("slow")
+		done <- err
+	}()
+	for atomic.LoadInt64(&g.limiter.inFlight) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := g.Get("tom"); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expect ErrOverloaded, got %v", err)
+	}
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Get("jack"); err != nil {
+		t.Fatalf("the token of the rejected load should be refunded: %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package ruoCache
 
-import pb"ruoCache/ruoCachePb"
+import (
+	pb "ruoCache/ruoCachePb"
+	"time"
+)
 
 // 节点选择器
 type PeerPicker interface {
@@ -8,8 +11,38 @@ type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
 }
 
+// 可以向所有节点广播的节点选择器
+type PeerBroadcaster interface {
+	PeerPicker
+	// 返回除自己以外的所有节点
+	AllPeers() []PeerGetter
+}
 
 type PeerGetter interface {
 	// 从对应 group 查找缓存值
 	Get(in *pb.Request, out *pb.Response) (error)
 }
+
+// 可以保存热点 key 副本的节点, 没有实现时不向它复制
+type Replicator interface {
+	// 让对方在租期内保留一份热点 key 的副本
+	Replicate(in *pb.Replica) error
+}
+
+// 可以按 tag 删除缓存的节点, 没有实现时不向它广播
+type TagInvalidator interface {
+	// 删除对方分组中带有 tag 的所有缓存
+	InvalidateTag(in *pb.InvalidateTagRequest) error
+}
+
+// 可以写入和删除缓存的节点, 没有数据源的分组通过它把写入交给 key 的负责节点
+type PeerWriter interface {
+	// 写入对方分组的缓存, ttl 大于 0 时代替分组的过期时间
+	Set(group, key string, value []byte, ttl time.Duration) error
+	// 删除对方分组的缓存, 返回删除之前对方是否缓存了这个 key
+	Delete(group, key string) (bool, error)
+	// 对方缓存的版本号为 expected 时才写入, 成功时返回新的版本号, 不一致时返回 ErrVersionMismatch
+	CompareAndSet(group, key string, expected uint64, value []byte, ttl time.Duration) (uint64, error)
+	// 返回对方缓存的版本号, 不会加载数据源。对方没有缓存时 ok 为 false
+	Version(group, key string) (version uint64, ok bool, err error)
+}
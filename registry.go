@@ -0,0 +1,115 @@
+// Registry 持有一组分组和它们共享的内存上限, 不同的 Registry 之间互不影响,
+// 同一个进程里可以运行多个相互隔离的缓存 (例如并行的测试)。
+// 包级别的 NewGroup、GetGroup 等函数都作用在 DefaultRegistry 上。
+package ruoCache
+
+import (
+	"fmt"
+	"sync"
+)
+
+type Registry struct {
+	mutex  sync.RWMutex
+	groups map[string]*Group
+	memory *memoryManager
+}
+
+// 新建一个空的 Registry
+func NewRegistry() *Registry {
+	return newRegistry(make(map[string]*Group))
+}
+
+func newRegistry(groups map[string]*Group) *Registry {
+	return &Registry{
+		groups: groups,
+		memory: newMemoryManager(),
+	}
+}
+
+var (
+	// 包级别的函数使用的 Registry
+	DefaultRegistry = newRegistry(Groups)
+)
+
+// 新建一个分组的实例, 和 MustNewGroup 相同
+//
+// Deprecated: 名称已经存在时 panic, 使用返回错误的 NewGroupWithOptions,
+// 或者在初始化时使用 MustNewGroup 明确表示出错时 panic
+func (r *Registry) NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+	return r.MustNewGroup(name, cacheBytes, getter)
+}
+
+// 新建一个分组的实例, 配置不合法或名称已经存在时 panic, 用于程序初始化和测试
+func (r *Registry) MustNewGroup(name string, cacheBytes int64, getter Getter) *Group {
+	g, err := r.NewGroupWithOptions(name, getter, WithCacheBytes(cacheBytes))
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// 按配置新建一个分组的实例, 名称已经存在时返回 ErrGroupExists。
+// 需要处理错误的调用方都应该使用这个函数
+func (r *Registry) NewGroupWithOptions(name string, getter Getter, opts ...GroupOption) (*Group, error) {
+	g, err := buildGroup(name, getter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.groups[name]; ok {
+		g.Close()
+		return nil, fmt.Errorf("%w: %s", ErrGroupExists, name)
+	}
+	r.groups[name] = g
+	g.registry = r
+	r.memory.register(g)
+	g.logger.Printf("new Group %s", name)
+	return g, nil
+}
+
+// 获取一个分组
+func (r *Registry) GetGroup(name string) *Group {
+	r.mutex.RLock()
+	g := r.groups[name]
+	r.mutex.RUnlock()
+	return g
+}
+
+// 获取所有分组
+func (r *Registry) Groups() []*Group {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	groups := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// 关闭的分组从 Registry 中移除, 同名的新分组不受影响
+func (r *Registry) remove(g *Group) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.groups[g.name] == g {
+		delete(r.groups, g.name)
+	}
+}
+
+// 删除并关闭分组
+func (r *Registry) DeleteGroup(name string) error {
+	r.mutex.Lock()
+	g, ok := r.groups[name]
+	delete(r.groups, name)
+	r.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("no such group: %s", name)
+	}
+	return g.Close()
+}
+
+// 设置 Registry 中所有分组共享的内存上限, 0 表示不限制
+func (r *Registry) SetMemoryBudget(bytes int64) {
+	r.memory.setBudget(bytes)
+}
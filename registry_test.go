@@ -0,0 +1,88 @@
+package ruoCache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	pb "ruoCache/ruoCachePb"
+	"strings"
+	"testing"
+)
+
+func TestRegistryIsolation(t *testing.T) {
+	newRegistryWithScores := func(prefix string) *Registry {
+		r := NewRegistry()
+		r.NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			return []byte(prefix + key), nil
+		}))
+		return r
+	}
+	r1, r2 := newRegistryWithScores("r1:"), newRegistryWithScores("r2:")
+	if r1.GetGroup("scores") == r2.GetGroup("scores") {
+		t.Fatalf("registries should not share groups")
+	}
+	if GetGroup("scores") == r1.GetGroup("scores") {
+		t.Fatalf("a new registry should not touch DefaultRegistry")
+	}
+	if _, err := r1.NewGroupWithOptions("scores", r1.GetGroup("scores").getter); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expect ErrGroupExists, got %v", err)
+	}
+
+	for prefix, r := range map[string]*Registry{"r1:": r1, "r2:": r2} {
+		pool := NewHttpPoolWithRegistry("self", r)
+		server := httptest.NewServer(pool)
+		res, err := http.Get(server.URL + defaultBasePath + "scores/tom")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		out := &pb.Response{}
+		if err := proto.Unmarshal(body, out); err != nil {
+			t.Fatal(err)
+		}
+		if string(out.GetValue()) != prefix+"tom" {
+			t.Fatalf("expect %s, got %s", prefix+"tom", out.GetValue())
+		}
+		res, err = http.Get(server.URL + defaultBasePath + "missing/tom")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusNotFound {
+			t.Fatalf("expect 404 for a group outside the registry, got %d", res.StatusCode)
+		}
+		server.Close()
+	}
+
+	if err := r1.DeleteGroup("scores"); err != nil {
+		t.Fatal(err)
+	}
+	if r1.GetGroup("scores") != nil || r2.GetGroup("scores") == nil {
+		t.Fatalf("DeleteGroup should only affect its own registry")
+	}
+}
+
+func TestRegistryMemoryBudget(t *testing.T) {
+	r1, r2 := NewRegistry(), NewRegistry()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	})
+	g1 := r1.NewGroup("budget", 1<<20, getter)
+	g2 := r2.NewGroup("budget", 1<<20, getter)
+	r1.SetMemoryBudget(4 << 10)
+	value := strings.Repeat("x", 1<<10)
+	for i := 0; i < 16; i++ {
+		g1.Set(fmt.Sprintf("key%d", i), value)
+		g2.Set(fmt.Sprintf("key%d", i), value)
+	}
+	if b := g1.mainCache.bytes(); b > 4<<10 {
+		t.Fatalf("r1 exceeds its budget: %d", b)
+	}
+	if b := g2.mainCache.bytes(); b < 16<<10 {
+		t.Fatalf("r2 should not be limited by r1's budget: %d", b)
+	}
+}
@@ -0,0 +1,262 @@
+package resp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"ruoCache"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type command struct {
+	// 参数个数 (包括命令名), 负数表示至少 -arity 个
+	arity     int
+	needGroup bool
+	fn        func(sess *session, g *ruoCache.Group, args []string)
+}
+
+var commands = map[string]command{
+	"PING":    {-1, false, ping},
+	"ECHO":    {2, false, echo},
+	"QUIT":    {-1, false, quit},
+	"HELLO":   {-1, false, hello},
+	"COMMAND": {-1, false, commandInfo},
+	"SELECT":  {2, false, selectGroup},
+	"INFO":    {-1, false, info},
+	"GET":     {2, true, get},
+	"MGET":    {-2, true, mget},
+	"SET":     {-3, true, set},
+	"DEL":     {-2, true, del},
+	"EXISTS":  {-2, true, exists},
+	"TTL":     {2, true, ttl},
+	"PTTL":    {2, true, ttl},
+	"EXPIRE":  {3, true, expire},
+}
+
+func ping(sess *session, _ *ruoCache.Group, args []string) {
+	switch len(args) {
+	case 0:
+		sess.w.simple("PONG")
+	case 1:
+		sess.w.bulkString(args[0])
+	default:
+		sess.w.errorf("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func echo(sess *session, _ *ruoCache.Group, args []string) {
+	sess.w.bulkString(args[0])
+}
+
+func quit(sess *session, _ *ruoCache.Group, _ []string) {
+	sess.w.simple("OK")
+	sess.quit = true
+}
+
+// HELLO [2|3], 切换协议版本并返回服务信息。不支持 AUTH
+func hello(sess *session, _ *ruoCache.Group, args []string) {
+	if len(args) > 0 {
+		proto, err := strconv.Atoi(args[0])
+		if err != nil {
+			sess.w.errorf("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if proto != 2 && proto != 3 {
+			sess.w.errorf("NOPROTO unsupported protocol version")
+			return
+		}
+		for _, arg := range args[1:] {
+			if strings.EqualFold(arg, "AUTH") {
+				sess.w.errorf("ERR AUTH is not supported")
+				return
+			}
+		}
+		sess.w.proto = proto
+	}
+	sess.w.mapHeader(5)
+	sess.w.bulkString("server")
+	sess.w.bulkString("ruocache")
+	sess.w.bulkString("proto")
+	sess.w.integer(int64(sess.w.proto))
+	sess.w.bulkString("id")
+	sess.w.integer(sess.id)
+	sess.w.bulkString("mode")
+	sess.w.bulkString("standalone")
+	sess.w.bulkString("role")
+	sess.w.bulkString("master")
+}
+
+// 客户端启动时会查询命令列表, 返回空数组即可
+func commandInfo(sess *session, _ *ruoCache.Group, _ []string) {
+	sess.w.array(0)
+}
+
+// SELECT <分组名>, 切换当前连接使用的分组
+func selectGroup(sess *session, _ *ruoCache.Group, args []string) {
+	if sess.server.registry().GetGroup(args[0]) == nil {
+		sess.w.errorf("ERR no such group '%s'", args[0])
+		return
+	}
+	sess.group = args[0]
+	sess.w.simple("OK")
+}
+
+func info(sess *session, _ *ruoCache.Group, _ []string) {
+	var b strings.Builder
+	b.WriteString("# Server\r\n")
+	b.WriteString("ruocache_mode:standalone\r\n")
+	fmt.Fprintf(&b, "resp_proto:%d\r\n", sess.w.proto)
+	if g := sess.server.registry().GetGroup(sess.group); g != nil {
+		stats := g.LoadStats()
+		b.WriteString("\r\n# Group\r\n")
+		fmt.Fprintf(&b, "group:%s\r\n", g.Name())
+		fmt.Fprintf(&b, "loads:%d\r\n", stats.Loads)
+		fmt.Fprintf(&b, "loads_rejected:%d\r\n", stats.Rejected)
+		fmt.Fprintf(&b, "loads_in_flight:%d\r\n", stats.InFlight)
+	}
+	var names []string
+	for _, g := range sess.server.registry().Groups() {
+		names = append(names, g.Name())
+	}
+	sort.Strings(names)
+	b.WriteString("\r\n# Keyspace\r\n")
+	fmt.Fprintf(&b, "groups:%s\r\n", strings.Join(names, ","))
+	sess.w.bulkString(b.String())
+}
+
+// 读取一个 key, 只有数据源返回 ErrNotFound 时当作 key 不存在, 其他错误返回给客户端
+func lookup(g *ruoCache.Group, key string) (ruoCache.ByteView, bool, error) {
+	v, err := g.Get(key)
+	switch {
+	case err == nil:
+		return v, true, nil
+	case errors.Is(err, ruoCache.ErrNotFound):
+		return v, false, nil
+	default:
+		return v, false, err
+	}
+}
+
+func get(sess *session, g *ruoCache.Group, args []string) {
+	v, ok, err := lookup(g, args[0])
+	switch {
+	case err != nil:
+		sess.w.errorf("ERR %v", err)
+	case !ok:
+		sess.w.null()
+	default:
+		sess.w.bulk(v)
+	}
+}
+
+// 一个 key 出错时整条命令返回错误, 不会只返回一部分
+func mget(sess *session, g *ruoCache.Group, args []string) {
+	values := make([]ruoCache.ByteView, len(args))
+	found := make([]bool, len(args))
+	for i, key := range args {
+		v, ok, err := lookup(g, key)
+		if err != nil {
+			sess.w.errorf("ERR %v", err)
+			return
+		}
+		values[i], found[i] = v, ok
+	}
+	sess.w.array(len(values))
+	for i, v := range values {
+		if found[i] {
+			sess.w.bulk(v)
+		} else {
+			sess.w.null()
+		}
+	}
+}
+
+// SET key value [EX seconds | PX milliseconds]
+func set(sess *session, g *ruoCache.Group, args []string) {
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	for i := 2; i < len(args); i += 2 {
+		opt := strings.ToUpper(args[i])
+		if (opt != "EX" && opt != "PX") || ttl != 0 || i+1 >= len(args) {
+			sess.w.errorf("ERR syntax error")
+			return
+		}
+		unit := time.Second
+		if opt == "PX" {
+			unit = time.Millisecond
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || n <= 0 || n > math.MaxInt64/int64(unit) {
+			sess.w.errorf("ERR invalid expire time in 'set' command")
+			return
+		}
+		ttl = time.Duration(n) * unit
+	}
+	if err := g.SetOnOwner(key, value, ttl); err != nil {
+		sess.w.errorf("ERR %v", err)
+		return
+	}
+	sess.w.simple("OK")
+}
+
+// 返回删除前缓存在负责节点上的 key 的个数
+func del(sess *session, g *ruoCache.Group, args []string) {
+	var n int64
+	for _, key := range args {
+		existed, err := g.DeleteOnOwner(key)
+		if err != nil {
+			sess.w.errorf("ERR %v", err)
+			return
+		}
+		if existed {
+			n++
+		}
+	}
+	sess.w.integer(n)
+}
+
+func exists(sess *session, g *ruoCache.Group, args []string) {
+	var n int64
+	for _, key := range args {
+		if g.Exists(key) {
+			n++
+		}
+	}
+	sess.w.integer(n)
+}
+
+// TTL 和 PTTL: key 不存在返回 -2, 永不过期返回 -1
+func ttl(sess *session, g *ruoCache.Group, args []string) {
+	d, ok := g.TTL(args[0])
+	switch {
+	case !ok:
+		sess.w.integer(-2)
+	case d < 0:
+		sess.w.integer(-1)
+	case sess.cmd == "PTTL":
+		sess.w.integer(int64((d + time.Millisecond - 1) / time.Millisecond))
+	default:
+		sess.w.integer(int64((d + time.Second - 1) / time.Second))
+	}
+}
+
+// EXPIRE key seconds, key 不存在返回 0
+func expire(sess *session, g *ruoCache.Group, args []string) {
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		sess.w.errorf("ERR value is not an integer or out of range")
+		return
+	}
+	if n > math.MaxInt64/int64(time.Second) {
+		sess.w.errorf("ERR invalid expire time in 'expire' command")
+		return
+	}
+	if g.Expire(args[0], time.Duration(n)*time.Second) {
+		sess.w.integer(1)
+	} else {
+		sess.w.integer(0)
+	}
+}
@@ -0,0 +1,138 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"ruoCache"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxArgs      = 1 << 20   // 一条命令最多的参数个数
+	maxBulkBytes = 512 << 20 // 一个参数最大的长度, 和 Redis 一致
+	maxLineBytes = 64 << 10  // 内联命令和长度行的最大长度, 也是读缓冲区的大小
+)
+
+// 协议错误, 回复之后关闭连接
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+type reader struct {
+	*bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{bufio.NewReaderSize(r, maxLineBytes)}
+}
+
+// 读取一条命令, 支持 RESP 数组和 telnet 使用的内联命令
+func (r *reader) readCommand() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil // 和 Redis 一样忽略 *0 和 *-1
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError(fmt.Sprintf("expected '$', got '%.1s'", line))
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkBytes {
+			return nil, protocolError("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, protocolError("bulk string not terminated by CRLF")
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// 读取一行, 去掉结尾的 \r\n。一行最多 maxLineBytes 字节, 不会无限制地缓冲
+func (r *reader) readLine() (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", protocolError("too big inline request")
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// 按连接协商的协议版本写入回复
+type writer struct {
+	*bufio.Writer
+	proto int // 2 或 3
+}
+
+func (w *writer) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w *writer) errorf(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(msg) + "\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// 直接从缓存写出, 不复制一份 value
+func (w *writer) bulk(v ruoCache.ByteView) {
+	w.WriteString("$" + strconv.Itoa(v.Len()) + "\r\n")
+	v.WriteTo(w)
+	w.WriteString("\r\n")
+}
+
+func (w *writer) bulkString(s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (w *writer) null() {
+	if w.proto == 3 {
+		w.WriteString("_\r\n")
+		return
+	}
+	w.WriteString("$-1\r\n")
+}
+
+func (w *writer) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// RESP3 中的 map, RESP2 中用键值交替的数组表示
+func (w *writer) mapHeader(n int) {
+	if w.proto == 3 {
+		w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	w.array(n * 2)
+}
@@ -0,0 +1,204 @@
+// Package resp 提供兼容 Redis 协议 (RESP2/RESP3) 的 TCP 服务, redis-cli 等客户端可以直接访问分组。
+// 每个连接有自己的当前分组, 默认为 Server.Group, 可以用 SELECT <分组名> 切换。
+// 客户端可以一次发送多条命令 (pipelining), 读完缓冲区中的命令后再统一发送回复。
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"ruoCache"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 调用 Close 之后 Serve 返回的错误
+var ErrServerClosed = errors.New("resp: server closed")
+
+const idleTimeout = 5 * time.Minute // 连接空闲多久后关闭
+
+type Server struct {
+	Registry *ruoCache.Registry // 为空时使用 ruoCache.DefaultRegistry
+	Group    string             // 新连接的默认分组
+
+	mutex     sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	nextID    int64
+}
+
+// 在 addr 上监听并提供服务
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// 接受 l 上的连接, 每个连接一个协程。返回时 l 已经关闭
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.track(l, false)
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// 关闭所有监听和连接
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (s *Server) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+func (s *Server) track(l net.Listener, add bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// 记录连接, 返回连接的 ID。服务已经关闭时返回 0
+func (s *Server) trackConn(c net.Conn, add bool) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !add {
+		delete(s.conns, c)
+		return 0
+	}
+	if s.closed {
+		return 0
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	s.nextID++
+	return s.nextID
+}
+
+func (s *Server) registry() *ruoCache.Registry {
+	if s.Registry == nil {
+		return ruoCache.DefaultRegistry
+	}
+	return s.Registry
+}
+
+// 一个客户端连接的状态
+type session struct {
+	server *Server
+	id     int64
+	group  string
+	cmd    string // 正在执行的命令, 大写
+	r      *reader
+	w      *writer
+	quit   bool
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	id := s.trackConn(c, true)
+	defer c.Close()
+	if id == 0 {
+		return
+	}
+	defer s.trackConn(c, false)
+
+	sess := &session{
+		server: s,
+		id:     id,
+		group:  s.Group,
+		r:      newReader(c),
+		w:      &writer{Writer: bufio.NewWriter(c), proto: 2},
+	}
+	for !sess.quit {
+		c.SetReadDeadline(time.Now().Add(idleTimeout))
+		args, err := sess.r.readCommand()
+		if err != nil {
+			var pe protocolError
+			if errors.As(err, &pe) {
+				sess.w.errorf("ERR %v", pe)
+				sess.w.Flush()
+			}
+			return
+		}
+		if len(args) > 0 {
+			sess.dispatch(args)
+		}
+		// 缓冲区中还有命令时先不发送, 一批命令的回复合并写出
+		if sess.r.Buffered() == 0 {
+			if err := sess.w.Flush(); err != nil {
+				log.Printf("[RuoCache] resp: write to %s: %v", c.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+	sess.w.Flush()
+}
+
+func (sess *session) dispatch(args []string) {
+	name := strings.ToUpper(args[0])
+	sess.cmd = name
+	cmd, ok := commands[name]
+	if !ok {
+		sess.w.errorf("ERR unknown command '%s'", args[0])
+		return
+	}
+	if cmd.arity > 0 && len(args) != cmd.arity || cmd.arity < 0 && len(args) < -cmd.arity {
+		sess.w.errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+		return
+	}
+	if !cmd.needGroup {
+		cmd.fn(sess, nil, args[1:])
+		return
+	}
+	g := sess.server.registry().GetGroup(sess.group)
+	if g == nil {
+		sess.w.errorf("ERR no such group '%s'", sess.group)
+		return
+	}
+	cmd.fn(sess, g, args[1:])
+}
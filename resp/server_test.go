@@ -0,0 +1,367 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"ruoCache"
+	pb "ruoCache/ruoCachePb"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var db = map[string]string{
+	"Tom":  "630",
+	"Jack": "589",
+	"Sam":  "567",
+}
+
+type testServer struct {
+	*Server
+	addr string
+}
+
+func newTestServer(t *testing.T) (*testServer, *ruoCache.Registry) {
+	r := ruoCache.NewRegistry()
+	r.NewGroup("scores", 2<<10, ruoCache.GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		if key == "broken" {
+			return nil, errors.New("database is down")
+		}
+		return nil, fmt.Errorf("%s: %w", key, ruoCache.ErrNotFound)
+	}))
+	r.NewGroup("names", 2<<10, ruoCache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("name:" + key), nil
+	}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Registry: r, Group: "scores"}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return &testServer{Server: s, addr: l.Addr().String()}, r
+}
+
+// 原始的 socket 客户端, 直接收发协议内容
+type rawClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, s *testServer) *rawClient {
+	conn, err := net.Dial("tcp", s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &rawClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// 把命令编码成 RESP 数组
+func encode(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
+}
+
+func (c *rawClient) send(raw string) {
+	if _, err := io.WriteString(c.conn, raw); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// 读取固定的回复内容
+func (c *rawClient) expect(want string) {
+	c.t.Helper()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(c.r, got); err != nil {
+		c.t.Fatalf("reading %q: %v (got %q)", want, err, got)
+	}
+	if string(got) != want {
+		c.t.Fatalf("expect %q, got %q", want, got)
+	}
+}
+
+// 读取一个完整的回复, 聚合类型返回 []interface{}
+func (c *rawClient) reply() interface{} {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+', '-', '_':
+		return line
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(buf[:n])
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = c.reply()
+		}
+		return items
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func TestPipelining(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := dial(t, s)
+
+	// 所有命令一次写入, 回复按顺序返回
+	c.send(encode("PING") +
+		encode("SET", "k", "v") +
+		encode("GET", "k") +
+		encode("GET", "Tom") +
+		encode("GET", "unknown") +
+		encode("MGET", "k", "unknown", "Jack") +
+		encode("EXISTS", "k", "Tom", "Sam") +
+		encode("DEL", "k", "Sam") +
+		encode("EXISTS", "k") +
+		encode("ECHO", "hi"))
+	c.expect("+PONG\r\n" +
+		"+OK\r\n" +
+		"$1\r\nv\r\n" +
+		"$3\r\n630\r\n" +
+		"$-1\r\n" +
+		"*3\r\n$1\r\nv\r\n$-1\r\n$3\r\n589\r\n" +
+		":2\r\n" +
+		":1\r\n" +
+		":0\r\n" +
+		"$2\r\nhi\r\n")
+}
+
+func TestInlineCommands(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := dial(t, s)
+
+	c.send("PING\r\nGET Tom\r\n\r\nPING hello\n")
+	c.expect("+PONG\r\n$3\r\n630\r\n$5\r\nhello\r\n")
+}
+
+func TestTTLAndExpire(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := dial(t, s)
+
+	c.send(encode("SET", "k", "v", "EX", "100") +
+		encode("TTL", "k") +
+		encode("SET", "forever", "v") +
+		encode("TTL", "forever") +
+		encode("TTL", "unknown") +
+		encode("EXPIRE", "forever", "10") +
+		encode("TTL", "forever") +
+		encode("EXPIRE", "unknown", "10") +
+		encode("EXPIRE", "k", "0") +
+		encode("EXISTS", "k"))
+	c.expect("+OK\r\n:100\r\n+OK\r\n:-1\r\n:-2\r\n:1\r\n:10\r\n:0\r\n:1\r\n:0\r\n")
+
+	c.send(encode("SET", "short", "v", "PX", "50") + encode("PTTL", "short"))
+	c.expect("+OK\r\n")
+	if ms := c.reply().(int64); ms <= 0 || ms > 50 {
+		t.Fatalf("unexpected pttl %d", ms)
+	}
+	time.Sleep(100 * time.Millisecond)
+	c.send(encode("TTL", "short"))
+	c.expect(":-2\r\n")
+
+	c.send(encode("SET", "k", "v", "EX", "0") + encode("SET", "k", "v", "NX"))
+	c.expect("-ERR invalid expire time in 'set' command\r\n-ERR syntax error\r\n")
+
+	// 超过 time.Duration 范围的过期时间不能溢出成负数
+	c.send(encode("SET", "k", "v", "EX", "9223372037") +
+		encode("SET", "k", "v", "PX", "9223372036855") +
+		encode("SET", "k", "v", "PX", "9223372036854") +
+		encode("EXPIRE", "k", "9223372037") +
+		encode("EXISTS", "k"))
+	c.expect("-ERR invalid expire time in 'set' command\r\n" +
+		"-ERR invalid expire time in 'set' command\r\n" +
+		"+OK\r\n" +
+		"-ERR invalid expire time in 'expire' command\r\n" +
+		":1\r\n")
+}
+
+func TestHello(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := dial(t, s)
+
+	c.send(encode("HELLO"))
+	fields := c.reply().([]interface{})
+	if len(fields) != 10 || fields[0] != "server" || fields[3] != int64(2) {
+		t.Fatalf("unexpected RESP2 hello reply %v", fields)
+	}
+
+	c.send(encode("HELLO", "3"))
+	c.expect("%5\r\n")
+	for i := 0; i < 10; i++ {
+		c.reply()
+	}
+	c.send(encode("GET", "unknown") + encode("MGET", "unknown"))
+	c.expect("_\r\n*1\r\n_\r\n")
+
+	c.send(encode("HELLO", "4"))
+	c.expect("-NOPROTO unsupported protocol version\r\n")
+}
+
+func TestSelect(t *testing.T) {
+	s, r := newTestServer(t)
+	c := dial(t, s)
+
+	c.send(encode("SELECT", "names") + encode("GET", "Tom") + encode("SELECT", "nope") + encode("GET", "Tom"))
+	c.expect("+OK\r\n$8\r\nname:Tom\r\n-ERR no such group 'nope'\r\n$8\r\nname:Tom\r\n")
+
+	c.send(encode("INFO"))
+	info := c.reply().(string)
+	if !strings.Contains(info, "group:names\r\n") || !strings.Contains(info, "groups:names,scores\r\n") {
+		t.Fatalf("unexpected info %q", info)
+	}
+
+	// 当前分组被删除后返回错误
+	if err := r.DeleteGroup("names"); err != nil {
+		t.Fatal(err)
+	}
+	c.send(encode("GET", "Tom"))
+	c.expect("-ERR no such group 'names'\r\n")
+}
+
+func TestErrors(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := dial(t, s)
+
+	c.send(encode("FLUSHALL") + encode("GET") + encode("EXPIRE", "k", "x"))
+	c.expect("-ERR unknown command 'FLUSHALL'\r\n" +
+		"-ERR wrong number of arguments for 'get' command\r\n" +
+		"-ERR value is not an integer or out of range\r\n")
+
+	// 只有 key 不存在时返回空, 数据源的其他错误返回给客户端
+	c.send(encode("GET", "broken") + encode("MGET", "Tom", "broken") + encode("GET", "unknown"))
+	c.expect("-ERR database is down\r\n" +
+		"-ERR database is down\r\n" +
+		"$-1\r\n")
+
+	// 协议错误时回复之后关闭连接
+	c.send("*1\r\n+GET\r\n")
+	c.expect("-ERR Protocol error: expected '$', got '+'\r\n")
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expect the connection to be closed, got %v", err)
+	}
+}
+
+func TestQuitAndClose(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := dial(t, s)
+	c.send(encode("QUIT") + encode("PING"))
+	c.expect("+OK\r\n")
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expect the connection to be closed, got %v", err)
+	}
+
+	c = dial(t, s)
+	c.send(encode("PING"))
+	c.expect("+PONG\r\n")
+	s.Close()
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatalf("expect the connection to be closed by Close")
+	}
+}
+
+func TestMalformedCommands(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := dial(t, s)
+
+	// 和 Redis 一样忽略空的数组, 不能让进程崩溃
+	c.send("*-1\r\n*0\r\n*-5\r\n" + encode("PING"))
+	c.expect("+PONG\r\n")
+
+	// 超长的内联命令不会被无限制地缓冲
+	c.send(strings.Repeat("a", maxLineBytes))
+	c.expect("-ERR Protocol error: too big inline request\r\n")
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expect the connection to be closed, got %v", err)
+	}
+}
+
+// 把所有 key 交给同一个节点负责, 记录转发过来的写入
+type ownerPeer struct {
+	mutex sync.Mutex
+	data  map[string]string
+	ttls  map[string]time.Duration
+}
+
+func (p *ownerPeer) PickPeer(string) (ruoCache.PeerGetter, bool) { return p, true }
+
+func (p *ownerPeer) Get(*pb.Request, *pb.Response) error { return errors.New("not implemented") }
+
+func (p *ownerPeer) Set(group, key string, value []byte, ttl time.Duration) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.data[key] = string(value)
+	p.ttls[key] = ttl
+	return nil
+}
+
+func (p *ownerPeer) CompareAndSet(group, key string, expected uint64, value []byte, ttl time.Duration) (uint64, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (p *ownerPeer) Version(group, key string) (uint64, bool, error) {
+	return 0, false, errors.New("not implemented")
+}
+
+func (p *ownerPeer) Delete(group, key string) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, ok := p.data[key]
+	delete(p.data, key)
+	return ok, nil
+}
+
+// 集群中 SET 和 DEL 交给 key 的负责节点, 不写入本地
+func TestWritesGoToOwner(t *testing.T) {
+	s, r := newTestServer(t)
+	peer := &ownerPeer{data: map[string]string{}, ttls: map[string]time.Duration{}}
+	r.GetGroup("names").RegisterPeers(peer)
+	c := dial(t, s)
+
+	c.send(encode("SELECT", "names") +
+		encode("SET", "a", "1", "PX", "1500") +
+		encode("SET", "b", "2") +
+		encode("DEL", "a", "c") +
+		encode("EXISTS", "b"))
+	c.expect("+OK\r\n+OK\r\n+OK\r\n:1\r\n:0\r\n")
+
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	if len(peer.data) != 1 || peer.data["b"] != "2" || peer.ttls["a"] != 1500*time.Millisecond {
+		t.Fatalf("unexpected writes on the owner %v %v", peer.data, peer.ttls)
+	}
+}
@@ -0,0 +1,642 @@
+// Package restapi 提供读写分组的 JSON REST API:
+//
+//	GET    /v1/groups                      所有分组
+//	POST   /v1/groups                      新建分组 {"name", "cache_bytes", "ttl"}
+//	GET    /v1/groups/<group>              分组信息
+//	DELETE /v1/groups/<group>              删除分组
+//	GET    /v1/groups/<group>/keys/<key>   读取, Accept 为 application/octet-stream 时返回原始字节
+//	PUT    /v1/groups/<group>/keys/<key>   写入, JSON {"value", "encoding", "ttl"} 或原始字节 (ttl 放在查询参数中)
+//	DELETE /v1/groups/<group>/keys/<key>   删除
+//	POST   /v1/groups/<group>/batch/get    批量读取 {"keys"}
+//	POST   /v1/groups/<group>/batch/set    批量写入 {"entries", "ttl"}
+//	POST   /v1/groups/<group>/batch/delete 批量删除 {"keys"}
+//
+// JSON 中的值是字符串, 不是合法 UTF-8 的值以 base64 返回: 单个读取时 "encoding" 为 "base64",
+// 批量读取时放在 "base64_values" 中。写入二进制数据时使用原始字节或者 "encoding": "base64"。
+//
+// 集群中写入和删除交给 key 的负责节点。分组只能在各个节点的配置中定义, 集群中新建和删除分组返回 409。
+// 设置了 Authenticator 时按分组检查权限:
+// 读取需要 read, 写入和删除需要 write, 新建和删除分组需要 admin, 列出分组只返回可以读取的分组。
+//
+// 出错时返回 {"error": {"code", "message"}}
+package restapi
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"ruoCache"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	apiPrefix         = "/v1/groups"
+	maxBodyBytes      = 64 << 20 // 请求体的最大长度
+	maxBatchKeys      = 1000     // 批量接口一次最多的 key 个数
+	defaultGroupBytes = 64 << 20
+)
+
+// 缓存中没有这个 key, 和 ruoCache.ErrNotFound 是同一个错误, RESP 等服务据此判断 key 不存在
+var ErrNotFound = ruoCache.ErrNotFound
+
+// 没有数据源的分组使用的 Getter, 未命中时返回 ErrNotFound。通过 API 创建的分组都使用它
+var NoSource ruoCache.Getter = ruoCache.GetterFunc(func(string) ([]byte, error) {
+	return nil, ErrNotFound
+})
+
+// 错误响应中的 code
+const (
+	codeBadRequest       = "bad_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeGroupExists      = "group_exists"
+	codeClusterMode      = "cluster_mode"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeUnsupportedType  = "unsupported_media_type"
+	codeTooLarge         = "too_large"
+	codeOverloaded       = "overloaded"
+	codePeer             = "peer_unavailable"
+	codeInternal         = "internal"
+)
+
+type apiError struct {
+	status  int
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, code, format string, a ...interface{}) *apiError {
+	return &apiError{status: status, Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// 把分组返回的错误转换成对应的状态码
+func groupError(err error) *apiError {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return newAPIError(http.StatusNotFound, codeNotFound, "key not found")
+	case errors.Is(err, ruoCache.ErrGroupClosed):
+		return newAPIError(http.StatusNotFound, codeNotFound, "group not found")
+	case errors.Is(err, ruoCache.ErrOverloaded):
+		return newAPIError(http.StatusServiceUnavailable, codeOverloaded, "%v", err)
+	case errors.Is(err, ruoCache.ErrPeerUnavailable):
+		return newAPIError(http.StatusBadGateway, codePeer, "%v", err)
+	}
+	return newAPIError(http.StatusInternalServerError, codeInternal, "%v", err)
+}
+
+// 处理 API 请求
+type Handler struct {
+	registry *ruoCache.Registry
+	peers    ruoCache.PeerPicker // 为空时单机运行, 否则不能通过 API 新建和删除分组
+
+	mutex sync.Mutex
+	auth  ruoCache.Authenticator // 为空时不认证
+	acl   *ruoCache.ACL
+}
+
+// 返回 registry 中分组的 API。peers 不为空时表示运行在集群中, 分组需要各自通过 WithPeers 注册 peers,
+// 之后读取、写入和删除都交给 key 的负责节点
+func NewHandler(registry *ruoCache.Registry, peers ruoCache.PeerPicker) *Handler {
+	return &Handler{registry: registry, peers: peers}
+}
+
+// 设置认证和授权, acl 为空时认证通过即可访问所有分组
+func (s *Handler) SetAuth(auth ruoCache.Authenticator, acl *ruoCache.ACL) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.auth = auth
+	s.acl = acl
+}
+
+func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	principal, err := s.authenticate(w, r)
+	if err == nil {
+		err = s.route(w, r, principal)
+	}
+	if err != nil {
+		var ae *apiError
+		if !errors.As(err, &ae) {
+			ae = newAPIError(http.StatusInternalServerError, codeInternal, "%v", err)
+		}
+		writeJSON(w, ae.status, map[string]*apiError{"error": ae})
+	}
+}
+
+// 认证请求, 没有设置 Authenticator 时返回 nil
+func (s *Handler) authenticate(w http.ResponseWriter, r *http.Request) (*ruoCache.Principal, error) {
+	s.mutex.Lock()
+	auth := s.auth
+	s.mutex.Unlock()
+	if auth == nil {
+		return nil, nil
+	}
+	principal, err := auth.Authenticate(r)
+	if err != nil {
+		audit(r, "-", "-", err.Error())
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return nil, newAPIError(http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+	}
+	return principal, nil
+}
+
+// 检查 principal 在 group 上的权限, 没有设置 Authenticator 或 ACL 时都允许
+func (s *Handler) authorize(r *http.Request, principal *ruoCache.Principal, group string, perm ruoCache.Permission) error {
+	if !s.allowed(principal, group, perm) {
+		audit(r, principal.Name, group, "permission denied, need "+perm.String())
+		return newAPIError(http.StatusForbidden, codeForbidden, "%s permission on group %s is required", perm, group)
+	}
+	return nil
+}
+
+func (s *Handler) allowed(principal *ruoCache.Principal, group string, perm ruoCache.Permission) bool {
+	s.mutex.Lock()
+	acl := s.acl
+	s.mutex.Unlock()
+	return principal == nil || acl == nil || acl.Allowed(principal, group, perm)
+}
+
+func audit(r *http.Request, principal, group, reason string) {
+	log.Printf("[RuoCache] audit: deny %s %s from %s principal=%s group=%s: %s",
+		r.Method, r.URL.Path, r.RemoteAddr, principal, group, reason)
+}
+
+func (s *Handler) route(w http.ResponseWriter, r *http.Request, principal *ruoCache.Principal) error {
+	path := r.URL.EscapedPath()
+	if path != apiPrefix && !strings.HasPrefix(path, apiPrefix+"/") {
+		return newAPIError(http.StatusNotFound, codeNotFound, "no such endpoint: %s", r.URL.Path)
+	}
+	var parts []string
+	if rest := strings.TrimPrefix(path, apiPrefix+"/"); rest != path && rest != "" {
+		for _, p := range strings.Split(rest, "/") {
+			unescaped, err := url.PathUnescape(p)
+			if err != nil {
+				return newAPIError(http.StatusBadRequest, codeBadRequest, "bad path: %v", err)
+			}
+			parts = append(parts, unescaped)
+		}
+	}
+
+	switch {
+	case len(parts) == 0:
+		switch r.Method {
+		case http.MethodGet:
+			return s.listGroups(w, principal)
+		case http.MethodPost:
+			return s.createGroup(w, r, principal)
+		}
+		return methodNotAllowed(w, "GET, POST")
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			if err := s.authorize(r, principal, parts[0], ruoCache.PermRead); err != nil {
+				return err
+			}
+			return s.showGroup(w, parts[0])
+		case http.MethodDelete:
+			if err := s.authorize(r, principal, parts[0], ruoCache.PermAdmin); err != nil {
+				return err
+			}
+			return s.deleteGroup(w, parts[0])
+		}
+		return methodNotAllowed(w, "GET, DELETE")
+	case len(parts) == 3 && parts[1] == "keys":
+		perm := ruoCache.PermRead
+		if r.Method != http.MethodGet {
+			perm = ruoCache.PermWrite
+		}
+		if err := s.authorize(r, principal, parts[0], perm); err != nil {
+			return err
+		}
+		g, err := s.group(parts[0])
+		if err != nil {
+			return err
+		}
+		if parts[2] == "" {
+			return newAPIError(http.StatusBadRequest, codeBadRequest, "key is required")
+		}
+		switch r.Method {
+		case http.MethodGet:
+			return s.getKey(w, r, g, parts[2])
+		case http.MethodPut:
+			return s.putKey(w, r, g, parts[2])
+		case http.MethodDelete:
+			return s.deleteKey(w, g, parts[2])
+		}
+		return methodNotAllowed(w, "GET, PUT, DELETE")
+	case len(parts) == 3 && parts[1] == "batch":
+		if r.Method != http.MethodPost {
+			return methodNotAllowed(w, "POST")
+		}
+		perm := ruoCache.PermWrite
+		if parts[2] == "get" {
+			perm = ruoCache.PermRead
+		}
+		if err := s.authorize(r, principal, parts[0], perm); err != nil {
+			return err
+		}
+		g, err := s.group(parts[0])
+		if err != nil {
+			return err
+		}
+		switch parts[2] {
+		case "get":
+			return s.batchGet(w, r, g)
+		case "set":
+			return s.batchSet(w, r, g)
+		case "delete":
+			return s.batchDelete(w, r, g)
+		}
+	}
+	return newAPIError(http.StatusNotFound, codeNotFound, "no such endpoint: %s", r.URL.Path)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) error {
+	w.Header().Set("Allow", allow)
+	return newAPIError(http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+}
+
+func (s *Handler) group(name string) (*ruoCache.Group, error) {
+	g := s.registry.GetGroup(name)
+	if g == nil {
+		return nil, newAPIError(http.StatusNotFound, codeNotFound, "group %s not found", name)
+	}
+	return g, nil
+}
+
+type groupRequest struct {
+	Name       string   `json:"name"`
+	CacheBytes int64    `json:"cache_bytes"` // 为 0 时使用 64MB
+	TTL        duration `json:"ttl"`
+}
+
+type groupInfo struct {
+	Name     string `json:"name"`
+	Loads    uint64 `json:"loads"`
+	Rejected uint64 `json:"rejected"`
+	InFlight int64  `json:"in_flight"`
+}
+
+func newGroupInfo(g *ruoCache.Group) groupInfo {
+	stats := g.LoadStats()
+	return groupInfo{Name: g.Name(), Loads: stats.Loads, Rejected: stats.Rejected, InFlight: stats.InFlight}
+}
+
+// 只列出 principal 可以读取的分组
+func (s *Handler) listGroups(w http.ResponseWriter, principal *ruoCache.Principal) error {
+	infos := []groupInfo{}
+	for _, g := range s.registry.Groups() {
+		if s.allowed(principal, g.Name(), ruoCache.PermRead) {
+			infos = append(infos, newGroupInfo(g))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	writeJSON(w, http.StatusOK, map[string][]groupInfo{"groups": infos})
+	return nil
+}
+
+// 分组只在本节点上新建, 其他节点没有这个分组, 所以集群中不能通过 API 新建
+func (s *Handler) createGroup(w http.ResponseWriter, r *http.Request, principal *ruoCache.Principal) error {
+	if s.peers != nil {
+		return newAPIError(http.StatusConflict, codeClusterMode, "groups can not be created through the API in cluster mode")
+	}
+	var req groupRequest
+	if err := readJSON(w, r, &req); err != nil {
+		return err
+	}
+	if req.Name == "" || strings.Contains(req.Name, "/") {
+		return newAPIError(http.StatusBadRequest, codeBadRequest, "invalid group name %q", req.Name)
+	}
+	if err := s.authorize(r, principal, req.Name, ruoCache.PermAdmin); err != nil {
+		return err
+	}
+	if req.CacheBytes < 0 {
+		return newAPIError(http.StatusBadRequest, codeBadRequest, "cache_bytes must not be negative")
+	}
+	if req.CacheBytes == 0 {
+		req.CacheBytes = defaultGroupBytes
+	}
+	g, err := s.registry.NewGroupWithOptions(req.Name, NoSource,
+		ruoCache.WithCacheBytes(req.CacheBytes), ruoCache.WithTTL(time.Duration(req.TTL)))
+	switch {
+	case errors.Is(err, ruoCache.ErrGroupExists):
+		return newAPIError(http.StatusConflict, codeGroupExists, "group %s already exists", req.Name)
+	case err != nil:
+		return newAPIError(http.StatusBadRequest, codeBadRequest, "%v", err)
+	}
+	w.Header().Set("Location", apiPrefix+"/"+url.PathEscape(req.Name))
+	writeJSON(w, http.StatusCreated, newGroupInfo(g))
+	return nil
+}
+
+func (s *Handler) showGroup(w http.ResponseWriter, name string) error {
+	g, err := s.group(name)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, newGroupInfo(g))
+	return nil
+}
+
+func (s *Handler) deleteGroup(w http.ResponseWriter, name string) error {
+	if s.peers != nil {
+		return newAPIError(http.StatusConflict, codeClusterMode, "groups can not be deleted through the API in cluster mode")
+	}
+	if _, err := s.group(name); err != nil {
+		return err
+	}
+	if err := s.registry.DeleteGroup(name); err != nil {
+		return newAPIError(http.StatusNotFound, codeNotFound, "%v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type valueRequest struct {
+	Value    *string  `json:"value"`
+	Encoding string   `json:"encoding"` // 为 "base64" 时 value 是 base64 编码的二进制数据
+	TTL      duration `json:"ttl"`
+}
+
+type valueResponse struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"` // 不是合法 UTF-8 的值为 "base64"
+	Version  uint64 `json:"version"`
+}
+
+const encodingBase64 = "base64"
+
+func (s *Handler) getKey(w http.ResponseWriter, r *http.Request, g *ruoCache.Group, key string) error {
+	v, err := g.Get(key)
+	if err != nil {
+		return groupError(err)
+	}
+	if accepts(r, "application/octet-stream") {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(v.Len()))
+		w.Header().Set("X-Ruocache-Version", strconv.FormatUint(v.Version(), 10))
+		v.WriteTo(w)
+		return nil
+	}
+	res := valueResponse{Key: key, Version: v.Version()}
+	res.Value, res.Encoding = encodeValue(v)
+	writeJSON(w, http.StatusOK, res)
+	return nil
+}
+
+// 合法的 UTF-8 原样返回, 否则返回 base64 编码, 都直接从 ByteView 读取
+func encodeValue(v ruoCache.ByteView) (value, encoding string) {
+	if validUTF8(v) {
+		return v.String(), ""
+	}
+	var b strings.Builder
+	b.Grow(base64.StdEncoding.EncodedLen(v.Len()))
+	encoder := base64.NewEncoder(base64.StdEncoding, &b)
+	v.WriteTo(encoder)
+	encoder.Close()
+	return b.String(), encodingBase64
+}
+
+// 逐个字符检查, 分块的值不需要先拼成一个切片
+func validUTF8(v ruoCache.ByteView) bool {
+	r := bufio.NewReader(v.Reader())
+	for {
+		c, size, err := r.ReadRune()
+		if err != nil {
+			return true
+		}
+		if c == utf8.RuneError && size == 1 {
+			return false
+		}
+	}
+}
+
+func (s *Handler) putKey(w http.ResponseWriter, r *http.Request, g *ruoCache.Group, key string) error {
+	var value string
+	var ttl time.Duration
+	if isJSON(r) {
+		var req valueRequest
+		if err := readJSON(w, r, &req); err != nil {
+			return err
+		}
+		if req.Value == nil {
+			return newAPIError(http.StatusBadRequest, codeBadRequest, "value is required")
+		}
+		value, ttl = *req.Value, time.Duration(req.TTL)
+		switch req.Encoding {
+		case "":
+		case encodingBase64:
+			b, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return newAPIError(http.StatusBadRequest, codeBadRequest, "invalid base64 value: %v", err)
+			}
+			value = string(b)
+		default:
+			return newAPIError(http.StatusBadRequest, codeBadRequest, "unknown encoding %q", req.Encoding)
+		}
+	} else {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			return newAPIError(http.StatusRequestEntityTooLarge, codeTooLarge, "%v", err)
+		}
+		value = string(body)
+		if q := r.URL.Query().Get("ttl"); q != "" {
+			if ttl, err = time.ParseDuration(q); err != nil {
+				return newAPIError(http.StatusBadRequest, codeBadRequest, "invalid ttl: %v", err)
+			}
+		}
+	}
+	if ttl < 0 {
+		return newAPIError(http.StatusBadRequest, codeBadRequest, "ttl must not be negative")
+	}
+	if err := s.set(g, key, value, ttl); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// 写入并设置过期时间, ttl 为 0 时使用分组的过期时间。key 由其他节点负责时写入负责的节点
+func (s *Handler) set(g *ruoCache.Group, key, value string, ttl time.Duration) error {
+	if key == "" {
+		return newAPIError(http.StatusBadRequest, codeBadRequest, "key is required")
+	}
+	if err := g.SetOnOwner(key, value, ttl); err != nil {
+		return groupError(err)
+	}
+	return nil
+}
+
+// 删除 key, 返回删除之前是否已经缓存。key 由其他节点负责时从负责的节点删除
+func (s *Handler) delete(g *ruoCache.Group, key string) (bool, error) {
+	existed, err := g.DeleteOnOwner(key)
+	if err != nil {
+		return false, groupError(err)
+	}
+	return existed, nil
+}
+
+func (s *Handler) deleteKey(w http.ResponseWriter, g *ruoCache.Group, key string) error {
+	existed, err := s.delete(g, key)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return newAPIError(http.StatusNotFound, codeNotFound, "key not found")
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type batchRequest struct {
+	Keys    []string          `json:"keys"`
+	Entries map[string]string `json:"entries"`
+	TTL     duration          `json:"ttl"`
+}
+
+func readBatch(w http.ResponseWriter, r *http.Request) (*batchRequest, error) {
+	var req batchRequest
+	if err := readJSON(w, r, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Keys) > maxBatchKeys || len(req.Entries) > maxBatchKeys {
+		return nil, newAPIError(http.StatusRequestEntityTooLarge, codeTooLarge, "at most %d keys per batch", maxBatchKeys)
+	}
+	return &req, nil
+}
+
+// 返回找到的值和不存在的 key, 其他错误时整个请求失败。不是合法 UTF-8 的值以 base64 放在 base64_values 中
+func (s *Handler) batchGet(w http.ResponseWriter, r *http.Request, g *ruoCache.Group) error {
+	req, err := readBatch(w, r)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]string, len(req.Keys))
+	encoded := make(map[string]string)
+	missing := []string{}
+	for _, key := range req.Keys {
+		v, err := g.Get(key)
+		switch {
+		case err == nil:
+			if value, encoding := encodeValue(v); encoding == "" {
+				values[key] = value
+			} else {
+				encoded[key] = value
+			}
+		case errors.Is(err, ErrNotFound):
+			missing = append(missing, key)
+		default:
+			return groupError(err)
+		}
+	}
+	res := map[string]interface{}{"values": values, "missing": missing}
+	if len(encoded) > 0 {
+		res["base64_values"] = encoded
+	}
+	writeJSON(w, http.StatusOK, res)
+	return nil
+}
+
+func (s *Handler) batchSet(w http.ResponseWriter, r *http.Request, g *ruoCache.Group) error {
+	req, err := readBatch(w, r)
+	if err != nil {
+		return err
+	}
+	if req.TTL < 0 {
+		return newAPIError(http.StatusBadRequest, codeBadRequest, "ttl must not be negative")
+	}
+	for key, value := range req.Entries {
+		if err := s.set(g, key, value, time.Duration(req.TTL)); err != nil {
+			return err
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"stored": len(req.Entries)})
+	return nil
+}
+
+func (s *Handler) batchDelete(w http.ResponseWriter, r *http.Request, g *ruoCache.Group) error {
+	req, err := readBatch(w, r)
+	if err != nil {
+		return err
+	}
+	deleted := 0
+	for _, key := range req.Keys {
+		existed, err := s.delete(g, key)
+		if err != nil {
+			return err
+		}
+		if existed {
+			deleted++
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+	return nil
+}
+
+// JSON 中的时间使用 "30s" 这样的字符串
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func isJSON(r *http.Request) bool {
+	t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return t == "application/json"
+}
+
+func accepts(r *http.Request, contentType string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if t, _, _ := mime.ParseMediaType(strings.TrimSpace(part)); t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if !isJSON(r) {
+		return newAPIError(http.StatusUnsupportedMediaType, codeUnsupportedType, "content type must be application/json")
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			return newAPIError(http.StatusRequestEntityTooLarge, codeTooLarge, "%v", err)
+		}
+		return newAPIError(http.StatusBadRequest, codeBadRequest, "invalid JSON: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,370 @@
+package restapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"ruoCache"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type apiClient struct {
+	t      *testing.T
+	server *httptest.Server
+}
+
+func newTestAPI(t *testing.T) *apiClient {
+	server := httptest.NewServer(NewHandler(ruoCache.NewRegistry(), nil))
+	t.Cleanup(server.Close)
+	return &apiClient{t: t, server: server}
+}
+
+// 发送请求, 返回状态码、Content-Type 和响应体
+func (c *apiClient) do(method, path, contentType, body string) (int, string, string) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res.StatusCode, res.Header.Get("Content-Type"), string(b)
+}
+
+func (c *apiClient) json(method, path, body string, wantStatus int, out interface{}) {
+	c.t.Helper()
+	status, contentType, b := c.do(method, path, "application/json", body)
+	if status != wantStatus {
+		c.t.Fatalf("%s %s: expect status %d, got %d: %s", method, path, wantStatus, status, b)
+	}
+	if out == nil {
+		return
+	}
+	if contentType != "application/json" {
+		c.t.Fatalf("%s %s: unexpected content type %q", method, path, contentType)
+	}
+	if err := json.Unmarshal([]byte(b), out); err != nil {
+		c.t.Fatalf("%s %s: %v: %s", method, path, err, b)
+	}
+}
+
+type errorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (c *apiClient) expectError(method, path, body string, wantStatus int, wantCode string) {
+	c.t.Helper()
+	var e errorBody
+	c.json(method, path, body, wantStatus, &e)
+	if e.Error.Code != wantCode || e.Error.Message == "" {
+		c.t.Fatalf("%s %s: unexpected error %+v", method, path, e)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	c := newTestAPI(t)
+
+	// 分组不会被隐式创建
+	c.expectError("GET", "/v1/groups/scores/keys/Tom", "", http.StatusNotFound, codeNotFound)
+	c.expectError("PUT", "/v1/groups/scores/keys/Tom", `{"value":"630"}`, http.StatusNotFound, codeNotFound)
+
+	var info groupInfo
+	c.json("POST", "/v1/groups", `{"name":"scores","cache_bytes":1024,"ttl":"1m"}`, http.StatusCreated, &info)
+	if info.Name != "scores" {
+		t.Fatalf("unexpected group %+v", info)
+	}
+	c.expectError("POST", "/v1/groups", `{"name":"scores"}`, http.StatusConflict, codeGroupExists)
+	c.expectError("POST", "/v1/groups", `{"name":""}`, http.StatusBadRequest, codeBadRequest)
+	c.expectError("POST", "/v1/groups", `{"name":"x","ttl":5}`, http.StatusBadRequest, codeBadRequest)
+	c.expectError("POST", "/v1/groups", `{"name":"x","unknown":1}`, http.StatusBadRequest, codeBadRequest)
+	c.json("POST", "/v1/groups", `{"name":"names"}`, http.StatusCreated, nil)
+
+	var list struct {
+		Groups []groupInfo `json:"groups"`
+	}
+	c.json("GET", "/v1/groups", "", http.StatusOK, &list)
+	if len(list.Groups) != 2 || list.Groups[0].Name != "names" || list.Groups[1].Name != "scores" {
+		t.Fatalf("unexpected groups %+v", list)
+	}
+
+	c.json("DELETE", "/v1/groups/names", "", http.StatusNoContent, nil)
+	c.expectError("GET", "/v1/groups/names", "", http.StatusNotFound, codeNotFound)
+	c.expectError("DELETE", "/v1/groups/names", "", http.StatusNotFound, codeNotFound)
+	c.expectError("PATCH", "/v1/groups/scores", "", http.StatusMethodNotAllowed, codeMethodNotAllowed)
+	c.expectError("GET", "/v2/groups", "", http.StatusNotFound, codeNotFound)
+}
+
+func TestKeys(t *testing.T) {
+	c := newTestAPI(t)
+	c.json("POST", "/v1/groups", `{"name":"scores"}`, http.StatusCreated, nil)
+
+	c.expectError("GET", "/v1/groups/scores/keys/Tom", "", http.StatusNotFound, codeNotFound)
+	c.json("PUT", "/v1/groups/scores/keys/Tom", `{"value":"630"}`, http.StatusNoContent, nil)
+
+	var v valueResponse
+	c.json("GET", "/v1/groups/scores/keys/Tom", "", http.StatusOK, &v)
+	if v.Key != "Tom" || v.Value != "630" || v.Version == 0 {
+		t.Fatalf("unexpected value %+v", v)
+	}
+
+	// 原始字节, key 中可以有转义的 /
+	status, _, _ := c.do("PUT", "/v1/groups/scores/keys/a%2Fb?ttl=1m", "application/octet-stream", "\x00\xff")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", status)
+	}
+	req, _ := http.NewRequest("GET", c.server.URL+"/v1/groups/scores/keys/a%2Fb", nil)
+	req.Header.Set("Accept", "application/octet-stream")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/octet-stream" || string(body) != "\x00\xff" {
+		t.Fatalf("unexpected raw response %d %q %q", res.StatusCode, res.Header.Get("Content-Type"), body)
+	}
+
+	c.expectError("PUT", "/v1/groups/scores/keys/Tom", `{}`, http.StatusBadRequest, codeBadRequest)
+	c.expectError("PUT", "/v1/groups/scores/keys/Tom", `{"value":`, http.StatusBadRequest, codeBadRequest)
+	c.expectError("PUT", "/v1/groups/scores/keys/Tom", `{"value":"1","ttl":"-1s"}`, http.StatusBadRequest, codeBadRequest)
+
+	c.json("DELETE", "/v1/groups/scores/keys/Tom", "", http.StatusNoContent, nil)
+	c.expectError("DELETE", "/v1/groups/scores/keys/Tom", "", http.StatusNotFound, codeNotFound)
+	c.expectError("GET", "/v1/groups/scores/keys/Tom", "", http.StatusNotFound, codeNotFound)
+}
+
+func TestKeyTTL(t *testing.T) {
+	c := newTestAPI(t)
+	c.json("POST", "/v1/groups", `{"name":"scores"}`, http.StatusCreated, nil)
+	c.json("PUT", "/v1/groups/scores/keys/Tom", `{"value":"630","ttl":"20ms"}`, http.StatusNoContent, nil)
+	time.Sleep(40 * time.Millisecond)
+	c.expectError("GET", "/v1/groups/scores/keys/Tom", "", http.StatusNotFound, codeNotFound)
+}
+
+func TestBatch(t *testing.T) {
+	c := newTestAPI(t)
+	c.json("POST", "/v1/groups", `{"name":"scores"}`, http.StatusCreated, nil)
+
+	var stored map[string]int
+	c.json("POST", "/v1/groups/scores/batch/set", `{"entries":{"Tom":"630","Jack":"589"}}`, http.StatusOK, &stored)
+	if stored["stored"] != 2 {
+		t.Fatalf("unexpected response %v", stored)
+	}
+
+	var got struct {
+		Values  map[string]string `json:"values"`
+		Missing []string          `json:"missing"`
+	}
+	c.json("POST", "/v1/groups/scores/batch/get", `{"keys":["Tom","Jack","Sam"]}`, http.StatusOK, &got)
+	if !reflect.DeepEqual(got.Values, map[string]string{"Tom": "630", "Jack": "589"}) || !reflect.DeepEqual(got.Missing, []string{"Sam"}) {
+		t.Fatalf("unexpected response %+v", got)
+	}
+
+	var deleted map[string]int
+	c.json("POST", "/v1/groups/scores/batch/delete", `{"keys":["Tom","Sam"]}`, http.StatusOK, &deleted)
+	if deleted["deleted"] != 1 {
+		t.Fatalf("unexpected response %v", deleted)
+	}
+
+	keys := make([]string, maxBatchKeys+1)
+	for i := range keys {
+		keys[i] = "k"
+	}
+	b, _ := json.Marshal(map[string][]string{"keys": keys})
+	c.expectError("POST", "/v1/groups/scores/batch/get", string(b), http.StatusRequestEntityTooLarge, codeTooLarge)
+	c.expectError("GET", "/v1/groups/scores/batch/get", "", http.StatusMethodNotAllowed, codeMethodNotAllowed)
+	c.expectError("POST", "/v1/groups/scores/batch/put", `{}`, http.StatusNotFound, codeNotFound)
+
+	status, _, _ := c.do("POST", "/v1/groups/scores/batch/get", "text/plain", `{"keys":[]}`)
+	if status != http.StatusUnsupportedMediaType {
+		t.Fatalf("expect 415 for non-JSON batch requests, got %d", status)
+	}
+}
+
+func TestBinaryValues(t *testing.T) {
+	c := newTestAPI(t)
+	c.json("POST", "/v1/groups", `{"name":"scores"}`, http.StatusCreated, nil)
+
+	if status, _, _ := c.do("PUT", "/v1/groups/scores/keys/raw", "application/octet-stream", "\x00\xff"); status != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", status)
+	}
+	var v valueResponse
+	c.json("GET", "/v1/groups/scores/keys/raw", "", http.StatusOK, &v)
+	if v.Value != "AP8=" || v.Encoding != "base64" {
+		t.Fatalf("binary values should be base64 encoded, got %+v", v)
+	}
+
+	c.json("PUT", "/v1/groups/scores/keys/json", `{"value":"AP8=","encoding":"base64"}`, http.StatusNoContent, nil)
+	c.json("GET", "/v1/groups/scores/keys/json", "", http.StatusOK, &v)
+	if v.Value != "AP8=" || v.Encoding != "base64" {
+		t.Fatalf("unexpected value %+v", v)
+	}
+	c.expectError("PUT", "/v1/groups/scores/keys/json", `{"value":"!","encoding":"base64"}`, http.StatusBadRequest, codeBadRequest)
+	c.expectError("PUT", "/v1/groups/scores/keys/json", `{"value":"x","encoding":"hex"}`, http.StatusBadRequest, codeBadRequest)
+
+	c.json("PUT", "/v1/groups/scores/keys/text", `{"value":"630"}`, http.StatusNoContent, nil)
+	var got struct {
+		Values       map[string]string `json:"values"`
+		Base64Values map[string]string `json:"base64_values"`
+	}
+	c.json("POST", "/v1/groups/scores/batch/get", `{"keys":["raw","text"]}`, http.StatusOK, &got)
+	if !reflect.DeepEqual(got.Values, map[string]string{"text": "630"}) || !reflect.DeepEqual(got.Base64Values, map[string]string{"raw": "AP8="}) {
+		t.Fatalf("unexpected response %+v", got)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	handler := NewHandler(ruoCache.NewRegistry(), nil)
+	acl := ruoCache.NewACL()
+	acl.Grant("alice", "scores", ruoCache.PermRead)
+	acl.Grant("admin", "*", ruoCache.PermAdmin)
+	handler.SetAuth(ruoCache.BearerTokens{"alice-token": "alice", "admin-token": "admin"}, acl)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	request := func(method, path, token, body string) int {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	tests := []struct {
+		method, path, token, body string
+		status                    int
+	}{
+		{"POST", "/v1/groups", "", `{"name":"scores"}`, http.StatusUnauthorized},
+		{"POST", "/v1/groups", "nope", `{"name":"scores"}`, http.StatusUnauthorized},
+		{"POST", "/v1/groups", "alice-token", `{"name":"scores"}`, http.StatusForbidden},
+		{"POST", "/v1/groups", "admin-token", `{"name":"scores"}`, http.StatusCreated},
+		{"POST", "/v1/groups", "admin-token", `{"name":"names"}`, http.StatusCreated},
+		{"PUT", "/v1/groups/scores/keys/Tom", "alice-token", `{"value":"630"}`, http.StatusForbidden},
+		{"PUT", "/v1/groups/scores/keys/Tom", "admin-token", `{"value":"630"}`, http.StatusNoContent},
+		{"GET", "/v1/groups/scores/keys/Tom", "alice-token", "", http.StatusOK},
+		{"GET", "/v1/groups/names/keys/Tom", "alice-token", "", http.StatusForbidden},
+		{"POST", "/v1/groups/scores/batch/get", "alice-token", `{"keys":["Tom"]}`, http.StatusOK},
+		{"POST", "/v1/groups/scores/batch/delete", "alice-token", `{"keys":["Tom"]}`, http.StatusForbidden},
+		{"DELETE", "/v1/groups/scores", "alice-token", "", http.StatusForbidden},
+		{"DELETE", "/v1/groups/names", "admin-token", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if status := request(tt.method, tt.path, tt.token, tt.body); status != tt.status {
+			t.Errorf("%s %s with %q: expect %d, got %d", tt.method, tt.path, tt.token, tt.status, status)
+		}
+	}
+
+	// 只列出可以读取的分组
+	handler.registry.NewGroupWithOptions("secret", NoSource)
+	req, _ := http.NewRequest("GET", server.URL+"/v1/groups", nil)
+	req.Header.Set("Authorization", "Bearer alice-token")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		Groups []groupInfo `json:"groups"`
+	}
+	json.NewDecoder(res.Body).Decode(&list)
+	res.Body.Close()
+	if len(list.Groups) != 1 || list.Groups[0].Name != "scores" {
+		t.Fatalf("unexpected groups %+v", list)
+	}
+}
+
+// 写入和删除交给 key 的负责节点, 从任意节点都能读到
+func TestCluster(t *testing.T) {
+	var nodes [2]struct {
+		pool  *ruoCache.HttpPool
+		api   *apiClient
+		loads int32
+	}
+	var urls []string
+	for i := range nodes {
+		registry := ruoCache.NewRegistry()
+		n := &nodes[i]
+		peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n.pool.ServeHTTP(w, r)
+		}))
+		t.Cleanup(peer.Close)
+		n.pool = ruoCache.NewHttpPoolWithRegistry(peer.URL, registry)
+		urls = append(urls, peer.URL)
+		_, err := registry.NewGroupWithOptions("scores", ruoCache.GetterFunc(func(key string) ([]byte, error) {
+			atomic.AddInt32(&n.loads, 1)
+			return NoSource.Get(key)
+		}), ruoCache.WithCacheBytes(defaultGroupBytes), ruoCache.WithPeers(n.pool))
+		if err != nil {
+			t.Fatal(err)
+		}
+		api := httptest.NewServer(NewHandler(registry, n.pool))
+		t.Cleanup(api.Close)
+		n.api = &apiClient{t: t, server: api}
+	}
+	for _, n := range nodes {
+		n.pool.Set(urls...)
+	}
+
+	// 分组只会在一个节点上新建或删除, 集群中拒绝
+	nodes[0].api.expectError("POST", "/v1/groups", `{"name":"names"}`, http.StatusConflict, codeClusterMode)
+	nodes[0].api.expectError("DELETE", "/v1/groups/scores", "", http.StatusConflict, codeClusterMode)
+
+	// 找一个由第二个节点负责的 key
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := nodes[0].pool.PickPeer(strconv.Itoa(i)); ok {
+			key = strconv.Itoa(i)
+		}
+	}
+	path := "/v1/groups/scores/keys/" + key
+	nodes[0].api.json("PUT", path, `{"value":"630"}`, http.StatusNoContent, nil)
+	for _, n := range nodes {
+		var v valueResponse
+		n.api.json("GET", path, "", http.StatusOK, &v)
+		if v.Value != "630" {
+			t.Fatalf("unexpected value %+v", v)
+		}
+	}
+
+	var stored map[string]int
+	nodes[0].api.json("POST", "/v1/groups/scores/batch/set", `{"entries":{"`+key+`":"589"}}`, http.StatusOK, &stored)
+	var v valueResponse
+	nodes[1].api.json("GET", path, "", http.StatusOK, &v)
+	if v.Value != "589" {
+		t.Fatalf("batch set should be routed to the owner, got %+v", v)
+	}
+
+	nodes[0].api.json("DELETE", path, "", http.StatusNoContent, nil)
+	nodes[0].api.expectError("DELETE", path, "", http.StatusNotFound, codeNotFound)
+	nodes[1].api.expectError("GET", path, "", http.StatusNotFound, codeNotFound)
+
+	// 负责的节点上没有时直接返回 404, 不会再从本地加载
+	nodes[0].api.expectError("GET", path, "", http.StatusNotFound, codeNotFound)
+	if loads := atomic.LoadInt32(&nodes[0].loads); loads != 0 {
+		t.Fatalf("a key owned by the peer should not be loaded locally, got %d loads", loads)
+	}
+}
@@ -1,11 +1,13 @@
 package ruoCache
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	pb "ruoCache/ruoCachePb"
 	"ruoCache/singleflight"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // region Group
@@ -13,9 +15,38 @@ type Group struct {
 	name      string
 	getter    Getter
 	mainCache cache
+	hotCache  cache   // 其他节点复制过来的热点 key
+	hotRatio  float64 // hotCache 和 mainCache 大小的比例, Resize 时保持不变, 为 0 时不调整 hotCache
 	peers     PeerPicker
+	ttl       time.Duration // 0 表示永不过期
+	chunkSize int           // 超过后分块保存
 
-	loader *singleflight.Group
+	compressor Compressor // 为空时不压缩
+	envelope   *envelope  // 为空时不加密
+
+	loader  *singleflight.Group
+	limiter *loadLimiter // 限制调用 Getter 的并发和速率
+	hotKeys *hotKeys
+
+	lastVersion uint64 // 最近分配或见过的版本号
+
+	setter   Setter
+	deleter  Deleter
+	behind   *writeBehind // 写回模式下的脏数据
+	keyLocks keyLocks     // 同时写入缓存和数据源时按 key 加锁
+
+	watchers *watchHub
+	scans    scanSnapshots // 进行中的 Scan
+
+	hits     uint64 // mainCache 的命中次数, 由 memoryManager 定期减半
+	minBytes int64  // 全局内存不足时至少保留的内存
+	memory   *memoryManager
+	logger   Logger
+	stats    StatsHooks
+	registry *Registry // 注册到的 Registry, 关闭时从中移除
+
+	closed    int32 // 关闭后为 1
+	closeOnce sync.Once
 }
 
 //
@@ -31,13 +62,20 @@ func (g *Group) load(key string) (value ByteView, err error) {
 	viewi, err := g.loader.Do(key, func() (interface{}, error) {
 		if g.peers != nil {
 			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
-					return value, nil
+				start := time.Now()
+				value, err = g.getFromPeer(peer, key)
+				g.recordLoad(true, start, err)
+				// 负责的节点上没有这个 key 时不再从本地加载
+				if err == nil || errors.Is(err, ErrNotFound) {
+					return value, err
 				}
-				log.Println("[ruoCache] Failed to get from peer", err)
+				g.logger.Printf("[RuoCache] Failed to get from peer %v", err)
 			}
 		}
-		return g.getLocally(key)
+		start := time.Now()
+		value, err := g.getLocally(key)
+		g.recordLoad(false, start, err)
+		return value, err
 	})
 
 	if err == nil {
@@ -48,80 +86,185 @@ func (g *Group) load(key string) (value ByteView, err error) {
 
 func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
 	req := &pb.Request{Group: g.name, Key: key}
+	if g.compressor != nil {
+		req.AcceptCompression = g.compressor.Name()
+	} else if cp, ok := peer.(ChunkedPeerGetter); ok {
+		value, err := cp.GetChunked(req, g.chunkSize)
+		if err != nil {
+			return ByteView{}, err
+		}
+		g.observeVersion(value.Version())
+		return value, nil
+	}
 	res := &pb.Response{}
 	err := peer.Get(req, res)
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	g.observeVersion(res.GetVersion())
+	return g.decompress(res)
 }
 
-var (
-	mutex  sync.RWMutex
-	Groups = make(map[string]*Group)
-)
+// DefaultRegistry 中的分组
+//
+// Deprecated: 不加锁直接访问不安全, 使用 GetGroup 或 Registry
+var Groups = make(map[string]*Group)
 
-// 新建一个分组的实例
+// 在 DefaultRegistry 中新建一个分组的实例, 和 MustNewGroup 相同
+//
+// Deprecated: 名称已经存在时 panic, 使用返回错误的 NewGroupWithOptions,
+// 或者在初始化时使用 MustNewGroup 明确表示出错时 panic
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
-	if getter == nil {
-		panic("nil getter")
-	}
-
-	mutex.Lock()
-	defer mutex.Unlock()
-	g := &Group{
-		name:   name,
-		getter: getter,
-		mainCache: cache{
-			cacheBytes: cacheBytes,
-		},
-		loader: &singleflight.Group{},
-	}
-	Groups[name] = g
-	log.Printf("new Group %s", name)
+	return DefaultRegistry.MustNewGroup(name, cacheBytes, getter)
+}
+
+// 在 DefaultRegistry 中新建一个分组的实例, 配置不合法或名称已经存在时 panic
+func MustNewGroup(name string, cacheBytes int64, getter Getter) *Group {
+	return DefaultRegistry.MustNewGroup(name, cacheBytes, getter)
+}
+
+// 新建分组但不注册到 Registry
+func newGroup(name string, cacheBytes int64, getter Getter) *Group {
+	g, err := buildGroup(name, getter, WithCacheBytes(cacheBytes))
+	if err != nil {
+		panic(err)
+	}
 	return g
 }
 
+// 分组的名称
+func (g *Group) Name() string {
+	return g.name
+}
+
 // 获取一个分组
 func GetGroup(name string) *Group {
-	mutex.RLock()
-	g := Groups[name]
-	mutex.RUnlock()
-	return g
+	return DefaultRegistry.GetGroup(name)
 }
 
 // 从 mainCache 中查找缓存，如果存在则返回缓存值。
 //
 func (g *Group) Get(key string) (ByteView, error) {
+	value, _, err := g.get(key, "")
+	return value, err
+}
+
+// accept 是调用方能解压的压缩算法, 命中用它压缩的缓存时直接返回压缩后的数据和算法名称
+func (g *Group) get(key, accept string) (ByteView, string, error) {
 	if key == "" {
-		return ByteView{}, fmt.Errorf("key is required")
+		return ByteView{}, "", fmt.Errorf("key is required")
+	}
+	if g.isClosed() {
+		return ByteView{}, "", ErrGroupClosed
 	}
-	if v, ok := g.mainCache.get(key); ok {
-		log.Println("[RuoCache] hit")
-		return v, nil
+	count := g.hotKeys.add(key)
+	if e, ok := g.mainCache.lookup(key); ok {
+		g.logger.Printf("[RuoCache] hit")
+		atomic.AddUint64(&g.hits, 1)
+		g.replicateIfHot(key, e, count)
+		if e.compressor != nil && e.envelope == nil && e.compressor.Name() == accept {
+			g.recordHit(false)
+			return e.value, accept, nil
+		}
+		value, err := e.view(key)
+		if err == nil {
+			g.recordHit(false)
+			return value, "", nil
+		}
+		// 例如加密用的密钥已经被删除, 当作没有命中重新加载
+		g.logger.Printf("[RuoCache] decode %s failed, reload: %v", key, err)
+		g.mainCache.removeIfVersion(key, e.value.version)
+	}
+	if v, ok := g.hotCache.get(key); ok {
+		g.logger.Printf("[RuoCache] hot cache hit")
+		g.recordHit(true)
+		return v, "", nil
 	}
+	g.recordMiss()
 	// 缓存不存在，则调用 load 方法
-	return g.load(key)
+	value, err := g.load(key)
+	return value, "", err
 }
 
-func (g *Group) Set(key , value string)  {
-	v := ByteView{b: cloneBytes([]byte(value))}
-	g.mainCache.add(key, v)
+// 写入缓存, 注册了数据源时同时写入数据源
+func (g *Group) Set(key , value string) error {
+	return g.set(key, value, nil)
+}
+
+// 同一个 key 的写入串行执行。版本号在写入数据源之后分配,
+// 比写入之前开始的加载的版本号大, 加载到的旧值不会覆盖这次写入
+func (g *Group) set(key, value string, tags []string) error {
+	unlock := g.keyLocks.lock(key)
+	defer unlock()
+	e, err := g.newEntry(key, g.newView([]byte(value), 0), tags)
+	if err != nil {
+		return err
+	}
+	if err := g.store(key, []byte(value)); err != nil {
+		return err
+	}
+	e.value.version = g.nextVersion()
+	g.mainCache.addIfNewer(key, e)
+	return nil
 }
 
+// 版本号在调用 Getter 之前分配, 加载期间的写入版本号更大, 加载到的旧值不会覆盖它。
+// 写回模式下还没有写入数据源的值以脏数据为准
 func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+	version := g.nextVersion()
+	if g.behind != nil {
+		if d, ok := g.behind.pending(key); ok {
+			if d.deleted {
+				return ByteView{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+			}
+			value := g.newView(d.value, version)
+			g.populateCache(key, value, nil)
+			return value, nil
+		}
+	}
+	bytes, tags, err := g.callGetter(key)
 	if err != nil {
 		return ByteView{}, err
 
 	}
-	value := ByteView{b: cloneBytes(bytes)}
-	g.populateCache(key, value)
+	value := g.newView(bytes, version)
+	g.populateCache(key, value, tags)
 	return value, nil
 }
 
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+// 拿到加载的许可后调用 Getter, Getter panic 时也会归还许可
+func (g *Group) callGetter(key string) ([]byte, []string, error) {
+	if err := g.limiter.acquire(g.name); err != nil {
+		return nil, nil, err
+	}
+	defer g.limiter.release()
+	if tg, ok := g.getter.(TaggedGetter); ok {
+		return tg.GetWithTags(key)
+	}
+	bytes, err := g.getter.Get(key)
+	return bytes, nil, err
+}
+
+func (g *Group) populateCache(key string, value ByteView, tags []string) {
+	e, err := g.newEntry(key, value, tags)
+	if err != nil {
+		g.logger.Printf("[RuoCache] Failed to populate cache %v", err)
+		return
+	}
+	g.mainCache.addIfNewer(key, e)
+}
+
+// 按分组的 ttl 设置过期时间, 按配置压缩、加密
+func (g *Group) newEntry(key string, value ByteView, tags []string) (*cacheEntry, error) {
+	e := &cacheEntry{value: value, tags: tags}
+	if g.ttl > 0 {
+		e.expire = time.Now().Add(g.ttl)
+	}
+	g.compress(e)
+	if err := g.encrypt(key, e); err != nil {
+		return nil, err
+	}
+	return e, nil
 }
 
 // endregion
@@ -0,0 +1,175 @@
+// 列出分组中缓存的 key。游标是上一页最后一个 key, 下一页从比它大的 key 开始,
+// 因此扫描过程中缓存被修改也不会重复或错乱, 只是期间新写入的 key 可能不会出现。
+// 第一页时复制并排序一次所有匹配的 key, 之后的页从这份快照中按游标二分查找,
+// 跳过期间已经删除或过期的 key; 快照过期或被挤出时重新生成。
+// HttpPool 上的管理接口: GET /<basepath>/_scan/<groupname>?prefix=&cursor=&limit=
+package ruoCache
+
+import (
+	"encoding/json"
+	"net/http"
+	"ruoCache/lru"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	scanPath         = "_scan" // /<basepath>/_scan/<groupname>
+	defaultScanLimit = 100
+	scanSnapshotTTL  = time.Minute // 快照多久没有使用后丢弃
+	maxScanSnapshots = 16          // 同时保留的快照个数
+)
+
+// 返回带有前缀的所有 key, 按字典序排序。
+// 持有锁时只复制 key, 排序在锁外进行, 不阻塞同时进行的读写
+func (c *cache) keys(prefix string) []string {
+	keys := c.matchingKeys(prefix)
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *cache) matchingKeys(prefix string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	var keys []string
+	now := time.Now()
+	c.lru.Range(func(key string, value lru.Value) bool {
+		if strings.HasPrefix(key, prefix) && !value.(*cacheEntry).expired(now) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// key 是否仍然缓存且没有过期, 不影响淘汰顺序
+func (c *cache) contains(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	v, ok := c.lru.Peek(key)
+	return ok && !v.(*cacheEntry).expired(time.Now())
+}
+
+// 一次扫描的 key 的快照, 已经排序
+type scanSnapshot struct {
+	keys     []string
+	lastUsed time.Time
+}
+
+// 进行中的扫描的快照, 按前缀和下一页的游标查找
+type scanSnapshots struct {
+	mutex     sync.Mutex
+	snapshots map[string]*scanSnapshot
+}
+
+func scanID(prefix, cursor string) string {
+	return prefix + "\x00" + cursor
+}
+
+// 取出 (prefix, cursor) 对应的快照, 同一个游标只能继续一次
+func (s *scanSnapshots) take(prefix, cursor string) *scanSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := scanID(prefix, cursor)
+	snapshot, ok := s.snapshots[id]
+	if !ok {
+		return nil
+	}
+	delete(s.snapshots, id)
+	if time.Since(snapshot.lastUsed) > scanSnapshotTTL {
+		return nil
+	}
+	return snapshot
+}
+
+// 保存快照供下一页使用, 超过个数时丢弃最久没有使用的
+func (s *scanSnapshots) put(prefix, cursor string, snapshot *scanSnapshot) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.snapshots == nil {
+		s.snapshots = make(map[string]*scanSnapshot)
+	}
+	snapshot.lastUsed = time.Now()
+	s.snapshots[scanID(prefix, cursor)] = snapshot
+	for len(s.snapshots) > maxScanSnapshots {
+		var oldest string
+		for id, ss := range s.snapshots {
+			if oldest == "" || ss.lastUsed.Before(s.snapshots[oldest].lastUsed) {
+				oldest = id
+			}
+		}
+		delete(s.snapshots, oldest)
+	}
+}
+
+// 按字典序扫描带有前缀的 key, 每次最多返回 limit 个。
+// cursor 第一次传空字符串, 之后传上一次返回的 next, next 为空表示扫描结束
+func (g *Group) Scan(prefix, cursor string, limit int) (keys []string, next string) {
+	if limit <= 0 {
+		limit = defaultScanLimit
+	}
+	snapshot := g.scans.take(prefix, cursor)
+	if snapshot == nil {
+		snapshot = &scanSnapshot{keys: g.mainCache.keys(prefix)}
+	}
+	i := sort.Search(len(snapshot.keys), func(i int) bool { return snapshot.keys[i] > cursor })
+	for ; i < len(snapshot.keys) && len(keys) < limit; i++ {
+		if g.mainCache.contains(snapshot.keys[i]) {
+			keys = append(keys, snapshot.keys[i])
+		}
+	}
+	if i < len(snapshot.keys) {
+		next = keys[len(keys)-1]
+		g.scans.put(prefix, next, snapshot)
+	}
+	return keys, next
+}
+
+type scanResponse struct {
+	Keys   []string `json:"keys"`
+	Cursor string   `json:"cursor"`
+}
+
+// 管理接口, 以 json 返回 Scan 的结果
+func (p *HttpPool) serveScan(w http.ResponseWriter, r *http.Request, groupName string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := p.registry.GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	limit := defaultScanLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "bad limit: "+s, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	keys, next := group.Scan(query.Get("prefix"), query.Get("cursor"), limit)
+	if keys == nil {
+		keys = []string{}
+	}
+	body, err := json.Marshal(scanResponse{Keys: keys, Cursor: next})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,81 @@
+package ruoCache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	g := newGroup("scan", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	for i := 0; i < 5; i++ {
+		g.Set(fmt.Sprintf("user:%d", i), "v")
+	}
+	g.Set("order:1", "v")
+
+	keys, cursor := g.Scan("user:", "", 2)
+	if !reflect.DeepEqual(keys, []string{"user:0", "user:1"}) || cursor != "user:1" {
+		t.Fatalf("unexpected first page %v %s", keys, cursor)
+	}
+
+	// 扫描期间的修改不影响后面的页, 删除的 key 被跳过, 新写入的 key 不在快照中
+	g.Delete("user:0")
+	g.Delete("user:2")
+	g.Set("user:10", "v")
+	keys, cursor = g.Scan("user:", cursor, 2)
+	if !reflect.DeepEqual(keys, []string{"user:3", "user:4"}) || cursor != "" {
+		t.Fatalf("unexpected last page %v %s", keys, cursor)
+	}
+
+	// 快照丢失时重新生成, 从游标之后继续
+	keys, cursor = g.Scan("user:", "user:1", 2)
+	if !reflect.DeepEqual(keys, []string{"user:10", "user:3"}) || cursor != "user:3" {
+		t.Fatalf("unexpected page without a snapshot %v %s", keys, cursor)
+	}
+}
+
+// 每一页都重新复制并排序所有 key 时, 扫描整个分组的耗时是 key 个数的平方级别
+func TestScanSortsOnce(t *testing.T) {
+	g := newGroup("scan-once", 64<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	for i := 0; i < 1000; i++ {
+		g.Set(fmt.Sprintf("key:%04d", i), "v")
+	}
+	keys, cursor := g.Scan("", "", 10)
+	snapshot := g.scans.snapshots[scanID("", cursor)]
+	total := len(keys)
+	for cursor != "" {
+		if g.scans.snapshots[scanID("", cursor)] != snapshot {
+			t.Fatalf("page after %s did not reuse the snapshot", cursor)
+		}
+		keys, cursor = g.Scan("", cursor, 10)
+		total += len(keys)
+	}
+	if total != 1000 || len(g.scans.snapshots) != 0 {
+		t.Fatalf("scanned %d keys, %d snapshots left", total, len(g.scans.snapshots))
+	}
+}
+
+func TestServeScan(t *testing.T) {
+	n := newTestNode(t)
+	n.group.Set("tom", "630")
+	n.group.Set("jack", "589")
+
+	res, err := http.Get(n.server.URL + defaultBasePath + scanPath + "/scores?limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var out scanResponse
+	if err = json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Keys, []string{"jack"}) || out.Cursor != "jack" {
+		t.Fatalf("unexpected response %+v", out)
+	}
+}
@@ -0,0 +1,101 @@
+// Group.Get 返回的是 ByteView, 调用方每次都要自己解码。Sink 负责接收缓存值并解码到目标中,
+// 常用的 string、[]byte、protobuf 和 JSON 都已经提供。
+package ruoCache
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang/protobuf/proto"
+)
+
+// 接收 Get 的结果, b 归 Sink 所有
+type Sink interface {
+	SetBytes(b []byte) error
+}
+
+// 不会保留 b 的 Sink, 直接读取缓存中的数据而不用复制
+type viewSink interface {
+	setView(v ByteView) error
+}
+
+// 查找缓存并写入 dest
+func (g *Group) GetInto(ctx context.Context, key string, dest Sink) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	view, err := g.Get(key)
+	if err != nil {
+		return err
+	}
+	if vs, ok := dest.(viewSink); ok {
+		return vs.setView(view)
+	}
+	return dest.SetBytes(view.ByteSlice())
+}
+
+type stringSink struct {
+	s *string
+}
+
+// 把结果保存到 *s
+func StringSink(s *string) Sink {
+	return &stringSink{s: s}
+}
+
+func (sink *stringSink) SetBytes(b []byte) error {
+	*sink.s = string(b)
+	return nil
+}
+
+func (sink *stringSink) setView(v ByteView) error {
+	*sink.s = v.String()
+	return nil
+}
+
+type byteSink struct {
+	b *[]byte
+}
+
+// 把结果保存到 *b
+func ByteSink(b *[]byte) Sink {
+	return &byteSink{b: b}
+}
+
+func (sink *byteSink) SetBytes(b []byte) error {
+	*sink.b = b
+	return nil
+}
+
+type protoSink struct {
+	m proto.Message
+}
+
+// 把结果按 protobuf 解码到 m
+func ProtoSink(m proto.Message) Sink {
+	return &protoSink{m: m}
+}
+
+func (sink *protoSink) SetBytes(b []byte) error {
+	return proto.Unmarshal(b, sink.m)
+}
+
+func (sink *protoSink) setView(v ByteView) error {
+	return proto.Unmarshal(v.contiguous(), sink.m)
+}
+
+type jsonSink struct {
+	v interface{}
+}
+
+// 把结果按 JSON 解码到 v, v 必须是指针
+func JSONSink(v interface{}) Sink {
+	return &jsonSink{v: v}
+}
+
+func (sink *jsonSink) SetBytes(b []byte) error {
+	return json.Unmarshal(b, sink.v)
+}
+
+func (sink *jsonSink) setView(v ByteView) error {
+	return json.Unmarshal(v.contiguous(), sink.v)
+}
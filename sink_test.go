@@ -0,0 +1,87 @@
+package ruoCache
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	pb "ruoCache/ruoCachePb"
+	"testing"
+)
+
+func TestSinks(t *testing.T) {
+	g := newGroup("sinks", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "proto" {
+			return proto.Marshal(&pb.Request{Group: "scores", Key: "tom"})
+		}
+		return []byte(`{"name":"tom","score":630}`), nil
+	}))
+	ctx := context.Background()
+
+	var s string
+	if err := g.GetInto(ctx, "json", StringSink(&s)); err != nil || s != `{"name":"tom","score":630}` {
+		t.Fatalf("StringSink got %q, %v", s, err)
+	}
+	var b []byte
+	if err := g.GetInto(ctx, "json", ByteSink(&b)); err != nil || string(b) != s {
+		t.Fatalf("ByteSink got %q, %v", b, err)
+	}
+	b[0] = 'x'
+	if v, _ := g.Get("json"); v.String() != s {
+		t.Fatalf("ByteSink should not share memory with the cache")
+	}
+	var score struct {
+		Name  string
+		Score int
+	}
+	if err := g.GetInto(ctx, "json", JSONSink(&score)); err != nil || score.Name != "tom" || score.Score != 630 {
+		t.Fatalf("JSONSink got %+v, %v", score, err)
+	}
+	req := &pb.Request{}
+	if err := g.GetInto(ctx, "proto", ProtoSink(req)); err != nil || req.GetKey() != "tom" {
+		t.Fatalf("ProtoSink got %v, %v", req, err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := g.GetInto(canceled, "json", StringSink(&s)); err != context.Canceled {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+}
+
+func TestTypedGroup(t *testing.T) {
+	type score struct {
+		Name  string
+		Score int
+	}
+	loads := 0
+	getter := TypedGetter[score](JSONCodec[score]{}, func(key string) (score, error) {
+		loads++
+		return score{Name: key, Score: 630}, nil
+	})
+	scores := NewTypedGroup[score](newGroup("typed", 2<<10, getter), JSONCodec[score]{})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		v, err := scores.Get(ctx, "tom")
+		if err != nil || v != (score{Name: "tom", Score: 630}) {
+			t.Fatalf("got %+v, %v", v, err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expect 1 load, got %d", loads)
+	}
+	if err := scores.Set("jack", score{Name: "jack", Score: 589}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := scores.Get(ctx, "jack"); err != nil || v.Score != 589 {
+		t.Fatalf("got %+v, %v", v, err)
+	}
+
+	requests := NewTypedGroup[*pb.Request](newGroup("typed-proto", 2<<10, GetterFunc(nil)),
+		ProtoCodec[*pb.Request]{New: func() *pb.Request { return &pb.Request{} }})
+	if err := requests.Set("tom", &pb.Request{Key: "tom"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := requests.Get(ctx, "tom"); err != nil || v.GetKey() != "tom" {
+		t.Fatalf("got %v, %v", v, err)
+	}
+}
@@ -0,0 +1,260 @@
+// Getter 只负责读, 写入时业务先更新数据库再更新缓存, 两者很容易不一致。
+// 分组可以注册一个 Setter (可选实现 Deleter), 之后通过 Group 写入:
+//   - WriteThrough: 先同步写入存储, 成功后再更新 mainCache
+//   - WriteBehind: 先更新 mainCache, 脏数据由后台协程定期批量写入存储, 失败时退避重试,
+//     Group.Close 时会再写入一次。写入数据源之前缓存未命中时以脏数据为准, 不会加载到数据源中的旧值
+//
+// 同一个 key 的写入按 key 加锁串行执行, 并发写入时缓存和数据源最后留下的是同一个值。
+package ruoCache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cespare/xxhash/v2"
+	"sync"
+	"time"
+)
+
+// 写入模式
+type WriteMode int
+
+const (
+	WriteThrough WriteMode = iota // 同步写入存储
+	WriteBehind                   // 异步批量写入存储
+)
+
+const (
+	defaultFlushInterval = time.Second
+	maxFlushBackoff      = time.Minute
+)
+
+// 写入数据源
+type Setter interface {
+	Set(key string, value []byte) error
+}
+
+// 从数据源删除
+type Deleter interface {
+	Delete(key string) error
+}
+
+// 注册数据源, 只能调用一次。setter 同时实现了 Deleter 时 Group.Delete 也会删除数据源
+func (g *Group) RegisterStore(setter Setter, mode WriteMode) {
+	g.registerStore(setter, mode, defaultFlushInterval)
+}
+
+func (g *Group) registerStore(setter Setter, mode WriteMode, interval time.Duration) {
+	if g.setter != nil {
+		panic("RegisterStore called more than once")
+	}
+	g.setter = setter
+	g.deleter, _ = setter.(Deleter)
+	if mode == WriteBehind {
+		g.behind = newWriteBehind(setter, g.deleter, interval, g.logger)
+	}
+}
+
+// 写入数据源, 写回模式下只标记为脏数据
+func (g *Group) store(key string, value []byte) error {
+	if g.isClosed() {
+		return ErrGroupClosed
+	}
+	value = cloneBytes(value) // 数据源拿到的不是缓存中的切片
+	switch {
+	case g.setter == nil:
+		return nil
+	case g.behind != nil:
+		return g.behind.markSet(key, value)
+	default:
+		return g.setter.Set(key, value)
+	}
+}
+
+// 删除缓存, 注册了 Deleter 时同时删除数据源
+func (g *Group) Delete(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if g.isClosed() {
+		return ErrGroupClosed
+	}
+	unlock := g.keyLocks.lock(key)
+	defer unlock()
+	switch {
+	case g.deleter == nil:
+	case g.behind != nil:
+		if err := g.behind.markDelete(key); err != nil {
+			return err
+		}
+	default:
+		if err := g.deleter.Delete(key); err != nil {
+			return err
+		}
+	}
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	return nil
+}
+
+// 按 key 的 hash 分片的锁, 同一个 key 的写入互斥, 不同的 key 大多不会互相等待
+type keyLocks [64]sync.Mutex
+
+func (l *keyLocks) lock(key string) (unlock func()) {
+	m := &l[xxhash.Sum64String(key)%uint64(len(l))]
+	m.Lock()
+	return m.Unlock
+}
+
+// 立即把脏数据写入数据源
+func (g *Group) Flush() error {
+	if g.behind == nil {
+		return nil
+	}
+	return g.behind.flush()
+}
+
+// 写回模式下等待写入的数据
+type dirtyEntry struct {
+	value   []byte
+	deleted bool
+	seq     uint64 // 第几次标记, 写入期间被重新标记的不能从 dirty 中删除
+}
+
+type writeBehind struct {
+	mutex   sync.Mutex
+	dirty   map[string]dirtyEntry // 写入数据源成功之后才删除, 读取时以它为准
+	seq     uint64
+	closed  bool // 关闭后不再接受新的脏数据
+	setter  Setter
+	deleter Deleter
+
+	flushMutex sync.Mutex // 同一时间只有一个 flush
+	interval   time.Duration
+	stop       chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+	logger     Logger
+}
+
+func newWriteBehind(setter Setter, deleter Deleter, interval time.Duration, logger Logger) *writeBehind {
+	w := &writeBehind{
+		dirty:    make(map[string]dirtyEntry),
+		setter:   setter,
+		deleter:  deleter,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		logger:   logger,
+	}
+	go w.run()
+	return w
+}
+
+func (w *writeBehind) markSet(key string, value []byte) error {
+	return w.mark(key, dirtyEntry{value: value})
+}
+
+func (w *writeBehind) markDelete(key string) error {
+	return w.mark(key, dirtyEntry{deleted: true})
+}
+
+// 关闭之后返回 ErrGroupClosed, 否则最后一次写入会漏掉它
+func (w *writeBehind) mark(key string, e dirtyEntry) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrGroupClosed
+	}
+	w.seq++
+	e.seq = w.seq
+	w.dirty[key] = e
+	return nil
+}
+
+// 还没有写入数据源的值
+func (w *writeBehind) pending(key string) (dirtyEntry, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	e, ok := w.dirty[key]
+	return e, ok
+}
+
+// 定期写入, 失败时等待时间加倍
+func (w *writeBehind) run() {
+	defer close(w.done)
+	wait := w.interval
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-timer.C:
+		}
+		if err := w.flush(); err != nil {
+			logf(w.logger, "[RuoCache] Failed to flush dirty entries %v", err)
+			if wait *= 2; wait > maxFlushBackoff {
+				wait = maxFlushBackoff
+			}
+		} else {
+			wait = w.interval
+		}
+		timer.Reset(wait)
+	}
+}
+
+// 写入当前所有的脏数据, 失败的保留到下一次重试
+func (w *writeBehind) flush() error {
+	w.flushMutex.Lock()
+	defer w.flushMutex.Unlock()
+	return w.flushLocked()
+}
+
+// 写入成功之前脏数据一直留在 dirty 中, 调用方需要持有 flushMutex
+func (w *writeBehind) flushLocked() error {
+	w.mutex.Lock()
+	batch := make(map[string]dirtyEntry, len(w.dirty))
+	for key, e := range w.dirty {
+		batch[key] = e
+	}
+	w.mutex.Unlock()
+
+	var failed []error
+	for key, e := range batch {
+		var err error
+		if e.deleted {
+			err = w.deleter.Delete(key)
+		} else {
+			err = w.setter.Set(key, e.value)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %v", key, err))
+			continue
+		}
+		w.mutex.Lock()
+		if w.dirty[key].seq == e.seq { // 期间有新的写入则保留新的
+			delete(w.dirty, key)
+		}
+		w.mutex.Unlock()
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d dirty entries not written, first error %v", len(failed), failed[0])
+	}
+	return nil
+}
+
+func (w *writeBehind) close() error {
+	err := errors.New("write behind already closed")
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+		// 在同一次加锁中拒绝新的脏数据并写入剩下的, 不会有写入落在两者之间
+		w.flushMutex.Lock()
+		defer w.flushMutex.Unlock()
+		w.mutex.Lock()
+		w.closed = true
+		w.mutex.Unlock()
+		err = w.flushLocked()
+	})
+	return err
+}
@@ -0,0 +1,211 @@
+package ruoCache
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录写入的数据源, 前 failures 次写入返回错误
+type memStore struct {
+	mutex    sync.Mutex
+	data     map[string]string
+	failures int
+}
+
+func (s *memStore) Set(key string, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return fmt.Errorf("store unavailable")
+	}
+	s.data[key] = string(value)
+	return nil
+}
+
+func (s *memStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (s *memStore) get(key string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func newStoreGroup(s *memStore) *Group {
+	return newGroup("store", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := s.get(key); ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+}
+
+func TestWriteThrough(t *testing.T) {
+	s := &memStore{data: make(map[string]string), failures: 1}
+	g := newStoreGroup(s)
+	g.RegisterStore(s, WriteThrough)
+
+	if err := g.Set("tom", "630"); err == nil {
+		t.Fatalf("expect the store error to be returned")
+	}
+	if _, ok := g.mainCache.get("tom"); ok {
+		t.Fatalf("cache should not be updated when the store fails")
+	}
+
+	if err := g.Set("tom", "631"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.get("tom"); v != "631" {
+		t.Fatalf("store was not written through, got %q", v)
+	}
+
+	if err := g.Delete("tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.get("tom"); ok {
+		t.Fatalf("tom should be deleted from the store")
+	}
+	if _, err := g.Get("tom"); err == nil {
+		t.Fatalf("tom should be deleted from the cache")
+	}
+}
+
+// 写入之后随机等待一会儿再返回, 让并发写入更新缓存的顺序和写入数据源的顺序不同
+type slowStore struct {
+	*memStore
+}
+
+func (s slowStore) Set(key string, value []byte) error {
+	err := s.memStore.Set(key, value)
+	time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+	return err
+}
+
+func TestConcurrentSet(t *testing.T) {
+	s := &memStore{data: make(map[string]string)}
+	g := newStoreGroup(s)
+	g.RegisterStore(slowStore{s}, WriteThrough)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := g.Set("tom", strconv.Itoa(i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	cached, _ := g.mainCache.get("tom")
+	if stored, _ := s.get("tom"); cached.String() != stored {
+		t.Fatalf("cache %q and store %q diverged", cached.String(), stored)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	s := &memStore{data: make(map[string]string), failures: 1}
+	g := newStoreGroup(s)
+	g.registerStore(s, WriteBehind, 10*time.Millisecond)
+
+	g.Set("tom", "630")
+	if v, err := g.Get("tom"); err != nil || v.String() != "630" {
+		t.Fatalf("cache should be updated immediately")
+	}
+
+	// 第一次写入失败, 之后重试成功
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := s.get("tom"); v == "630" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dirty entry was not flushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	g.Set("jack", "589")
+	g.Delete("tom")
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.get("jack"); v != "589" {
+		t.Fatalf("jack was not flushed on close")
+	}
+	if _, ok := s.get("tom"); ok {
+		t.Fatalf("delete of tom was not flushed on close")
+	}
+}
+
+// 加载期间写入的新值不能被加载到的旧值覆盖
+func TestLoadDoesNotOverwriteSet(t *testing.T) {
+	s := &memStore{data: map[string]string{"tom": "630"}}
+	entered, release := make(chan struct{}), make(chan struct{})
+	g := newGroup("slow-load", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		v, _ := s.get(key)
+		close(entered)
+		<-release
+		return []byte(v), nil
+	}))
+	g.RegisterStore(s, WriteThrough)
+
+	loaded := make(chan ByteView)
+	go func() {
+		v, _ := g.Get("tom")
+		loaded <- v
+	}()
+	<-entered
+	if err := g.Set("tom", "631"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if v := <-loaded; v.String() != "630" {
+		t.Fatalf("the load should return what the getter read, got %q", v)
+	}
+	if v, err := g.Get("tom"); err != nil || v.String() != "631" {
+		t.Fatalf("stale load overwrote the newer write, got %q %v", v, err)
+	}
+}
+
+// 写回模式下还没有写入数据源的删除和写入以缓存为准
+func TestWriteBehindPending(t *testing.T) {
+	s := &memStore{data: map[string]string{"tom": "630", "jack": "589"}}
+	g := newStoreGroup(s)
+	g.registerStore(s, WriteBehind, time.Hour)
+
+	if err := g.Delete("tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Get("tom"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted key should not be loaded from the store, got %v", err)
+	}
+	if err := g.Set("jack", "590"); err != nil {
+		t.Fatal(err)
+	}
+	g.mainCache.remove("jack")
+	if v, err := g.Get("jack"); err != nil || v.String() != "590" {
+		t.Fatalf("expect the pending value 590, got %q %v", v, err)
+	}
+
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.get("tom"); ok {
+		t.Fatalf("delete of tom was not flushed on close")
+	}
+	if err := g.behind.markSet("sam", []byte("1")); !errors.Is(err, ErrGroupClosed) {
+		t.Fatalf("writes after the final flush should be rejected, got %v", err)
+	}
+}
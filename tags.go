@@ -0,0 +1,107 @@
+// 一个用户的数据往往派生出很多缓存, 用户数据变化时需要把它们全部删除。
+// 写入缓存时可以给它打上 tag, 缓存中维护 tag -> keys 的索引,
+// Group.InvalidateTag 删除本地带有该 tag 的缓存, 并广播给其他节点。
+package ruoCache
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	pb "ruoCache/ruoCachePb"
+)
+
+const (
+	tagPath           = "_tag"   // /<basepath>/_tag/<groupname>
+	maxTagRequestSize = 64 << 10 // tag 删除请求只有一个 tag
+)
+
+// 写入缓存并打上 tag
+func (g *Group) SetWithTags(key, value string, tags ...string) error {
+	return g.set(key, value, tags)
+}
+
+// 删除所有节点上带有 tag 的缓存, 返回第一个广播失败的错误
+func (g *Group) InvalidateTag(tag string) error {
+	removed := g.invalidateTagLocally(tag)
+	g.logger.Printf("[RuoCache] invalidate tag %s, %d keys removed", tag, removed)
+
+	broadcaster, ok := g.peers.(PeerBroadcaster)
+	if !ok {
+		return nil
+	}
+	var firstErr error
+	for _, peer := range broadcaster.AllPeers() {
+		invalidator, ok := peer.(TagInvalidator)
+		if !ok {
+			continue
+		}
+		err := invalidator.InvalidateTag(&pb.InvalidateTagRequest{Group: g.name, Tag: tag})
+		if err != nil {
+			g.logger.Printf("[RuoCache] Failed to invalidate tag on peer %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// 只删除本节点上带有 tag 的缓存, 包括热点副本
+func (g *Group) invalidateTagLocally(tag string) int {
+	return len(g.mainCache.removeTag(tag)) + len(g.hotCache.removeTag(tag))
+}
+
+// 接收其他节点广播的 tag 删除请求
+func (p *HttpPool) serveInvalidateTag(w http.ResponseWriter, r *http.Request, groupName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := p.registry.GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxTagRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	in := &pb.InvalidateTagRequest{}
+	if err = proto.Unmarshal(body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	removed := group.invalidateTagLocally(in.GetTag())
+
+	body, err = proto.Marshal(&pb.InvalidateTagResponse{Removed: int64(removed)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+func (h *httpGetter) InvalidateTag(in *pb.InvalidateTagRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%v%v/%v", h.baseURL, tagPath, url.PathEscape(in.GetGroup()))
+	res, err := h.client.Post(u, "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+var _ TagInvalidator = (*httpGetter)(nil)
@@ -0,0 +1,109 @@
+package ruoCache
+
+import (
+	"bytes"
+	"net/http"
+	pb "ruoCache/ruoCachePb"
+	"testing"
+	"time"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t)}
+	urls := []string{nodes[0].server.URL, nodes[1].server.URL}
+	for _, n := range nodes {
+		n.pool.Set(urls...)
+	}
+
+	nodes[0].group.SetWithTags("user:1:profile", "tom", "user:1")
+	nodes[0].group.SetWithTags("user:1:friends", "jack", "user:1", "friends")
+	nodes[0].group.SetWithTags("user:2:profile", "sam", "user:2")
+	nodes[1].group.hotCache.addIfNewer("user:1:feed", &cacheEntry{
+		value:  ByteView{b: []byte("feed"), version: 1},
+		expire: time.Now().Add(time.Minute),
+		tags:   []string{"user:1"},
+	})
+
+	if err := nodes[0].group.InvalidateTag("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"user:1:profile", "user:1:friends"} {
+		if _, ok := nodes[0].group.mainCache.get(key); ok {
+			t.Fatalf("%s should be invalidated", key)
+		}
+	}
+	if _, ok := nodes[1].group.hotCache.get("user:1:feed"); ok {
+		t.Fatalf("invalidation was not broadcast to peers")
+	}
+	if _, ok := nodes[0].group.mainCache.get("user:2:profile"); !ok {
+		t.Fatalf("user:2:profile should not be invalidated")
+	}
+	if _, ok := nodes[0].group.mainCache.tags["friends"]; ok {
+		t.Fatalf("index of tag friends should be cleaned up")
+	}
+}
+
+// 只实现了 PeerGetter 的节点
+type getOnlyPeer struct{}
+
+func (getOnlyPeer) Get(in *pb.Request, out *pb.Response) error { return nil }
+
+type getOnlyPeers struct{}
+
+func (getOnlyPeers) PickPeer(key string) (PeerGetter, bool) { return nil, false }
+func (getOnlyPeers) AllPeers() []PeerGetter                 { return []PeerGetter{getOnlyPeer{}} }
+
+// 节点不支持 tag 时跳过, 不影响本地的删除
+func TestInvalidateTagSkipsPlainPeers(t *testing.T) {
+	g := newGroup("plain-peers", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	g.RegisterPeers(getOnlyPeers{})
+	g.SetWithTags("tom", "630", "t")
+	if err := g.InvalidateTag("t"); err != nil {
+		t.Fatal(err)
+	}
+	if g.Exists("tom") {
+		t.Fatalf("tom should be invalidated")
+	}
+}
+
+func TestTaggedGetter(t *testing.T) {
+	g := newGroup("tagged", 2<<10, TaggedGetterFunc(func(key string) ([]byte, []string, error) {
+		return []byte("value of " + key), []string{"tag:" + key}, nil
+	}))
+	if _, err := g.Get("tom"); err != nil {
+		t.Fatal(err)
+	}
+	if n := g.invalidateTagLocally("tag:tom"); n != 1 {
+		t.Fatalf("expect tom to be tagged by the getter, %d keys removed", n)
+	}
+}
+
+func TestTagIndexCleanup(t *testing.T) {
+	c := &cache{cacheBytes: 200} // 每个条目占用约 104 字节
+	c.set("k1", &cacheEntry{value: ByteView{b: []byte("12345")}, tags: []string{"a"}})
+	c.set("k2", &cacheEntry{value: ByteView{b: []byte("12345")}, tags: []string{"b"}})
+	if _, ok := c.tags["a"]; ok {
+		t.Fatalf("index of evicted key k1 should be removed")
+	}
+
+	// 覆盖写入时旧的 tag 也要清理
+	c.set("k2", &cacheEntry{value: ByteView{b: []byte("1")}, tags: []string{"c"}})
+	if _, ok := c.tags["b"]; ok || len(c.tags["c"]) != 1 {
+		t.Fatalf("index was not updated on overwrite: %v", c.tags)
+	}
+}
+
+func TestInvalidateTagTooLarge(t *testing.T) {
+	n := newTestNode(t)
+	body := bytes.NewReader(make([]byte, maxTagRequestSize+1))
+	res, err := http.Post(n.server.URL+defaultBasePath+tagPath+"/scores", "application/octet-stream", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expect 413 for an oversized request, got %s", res.Status)
+	}
+}
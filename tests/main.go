@@ -1,10 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
-	"net/http"
+	"net/url"
 	"ruoCache"
 )
 
@@ -18,60 +21,57 @@ func createGroup() *ruoCache.Group {
 	return createNewGroup("main")
 }
 
-func startCacheServer(addr string, addrs []string, ruo *ruoCache.Group) {
+func startCacheServer(addr string, addrs []string, ruo *ruoCache.Group, config *tls.Config) {
 	peers := ruoCache.NewHttpPool(addr)
+	if config != nil {
+		peers.SetTLSConfig(config)
+	}
 	peers.Set(addrs...)
 	ruo.RegisterPeers(peers)
 	log.Println("ruoCache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	u, err := url.Parse(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(peers.ListenAndServe(u.Host))
 }
 
-func startAPIServer(apiAddr string) {
-	http.Handle("/get", http.HandlerFunc(
-		func(w http.ResponseWriter, r *http.Request) {
-			key := r.URL.Query().Get("key")
-			group := r.URL.Query().Get("group")
-			if group == "" {
-				group = "main"
-			}
-			view, err := getGroup(group).Get(key)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/application/json")
-			w.Write(view.ByteSlice())
-		}))
-
-	http.Handle("/set", http.HandlerFunc(
-		func(w http.ResponseWriter, r *http.Request) {
-			key := r.URL.Query().Get("key")
-			val := r.URL.Query().Get("val")
-			group := r.URL.Query().Get("group")
-			if group == "" {
-				group = "main"
-			}
-			getGroup(group).Set(key, val)
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte("{\"status\":0,\"code\":200, \"msg\":\"success\"}"))
-		}))
-	log.Println("fontend server is running at", apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
-
+// 节点之间使用双向认证的 TLS
+func loadTLSConfig(certFile, keyFile, caFile string) *tls.Config {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ca := x509.NewCertPool()
+	if !ca.AppendCertsFromPEM(pem) {
+		log.Fatalf("no certificates found in %s", caFile)
+	}
+	return ruoCache.NewPeerTLSConfig(cert, ca)
 }
 
 func main() {
 	var port int
-	var api bool
+	var certFile, keyFile, caFile string
 	flag.IntVar(&port, "port", 8001, "ruoCache server port")
-	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.StringVar(&certFile, "cert", "", "peer certificate, enables mutual TLS between peers")
+	flag.StringVar(&keyFile, "key", "", "peer private key")
+	flag.StringVar(&caFile, "ca", "", "CA certificate used to verify peers")
 	flag.Parse()
 
-	apiAddr := "http://localhost:9999"
+	scheme := "http"
+	var config *tls.Config
+	if certFile != "" {
+		scheme = "https"
+		config = loadTLSConfig(certFile, keyFile, caFile)
+	}
 	addrMap := map[int]string{
-		8001: "http://localhost:8001",
-		8002: "http://localhost:8002",
-		8003: "http://localhost:8003",
+		8001: scheme + "://localhost:8001",
+		8002: scheme + "://localhost:8002",
+		8003: scheme + "://localhost:8003",
 	}
 
 	var addrs []string
@@ -80,10 +80,7 @@ func main() {
 	}
 
 	ruo := createGroup()
-	if api {
-		go startAPIServer(apiAddr)
-	}
-	startCacheServer(addrMap[port], []string(addrs), ruo)
+	startCacheServer(addrMap[port], []string(addrs), ruo, config)
 }
 
 func createNewGroup(name string) *ruoCache.Group {
@@ -96,11 +93,3 @@ func createNewGroup(name string) *ruoCache.Group {
 			return nil, fmt.Errorf("%s not exist", key)
 		}))
 }
-
-func getGroup(name string) *ruoCache.Group  {
-	g := ruoCache.GetGroup(name)
-	if g == nil {
-		return createNewGroup(name)
-	}
-	return g
-}
@@ -4,12 +4,12 @@ trap "rm server;kill 0" EXIT # 退出时自动删除临时文件
 go build -o server
 ./server -port=8001 &
 ./server -port=8002 &
-./server -port=8003 -api=1 &
+./server -port=8003 &
 
 sleep 2
 echo ">>> start test"
-curl "http://localhost:9999/api?key=Tom" &
-curl "http://localhost:9999/api?key=Tom" &
-curl "http://localhost:9999/api?key=Tom" &
+curl "http://localhost:8001/api/main/Tom" &
+curl "http://localhost:8001/api/main/Tom" &
+curl "http://localhost:8001/api/main/Tom" &
 
-wait
\ No newline at end of file
+wait
@@ -0,0 +1,88 @@
+// 节点之间可以使用 TLS 通信, 节点地址使用 https://。同一个 tls.Config 同时用于服务和访问其他节点:
+// Certificates 是自己的证书, RootCAs 用来校验其他节点的服务端证书 (证书必须和节点地址中的主机名一致)。
+// ClientAuth 为 tls.RequireAndVerifyClientCert 时启用双向认证, 请求方的证书由 ClientCAs 校验,
+// 并且证书中的身份必须和环上某个节点地址的主机名一致, 否则返回 403。
+package ruoCache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+)
+
+// 双向认证的 tls.Config, 自己的证书由 ca 签发, 其他节点的证书也必须由 ca 签发
+func NewPeerTLSConfig(cert tls.Certificate, ca *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca,
+		ClientCAs:    ca,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// 设置节点之间通信使用的 TLS 配置, 需要在 Set 之前调用
+func (p *HttpPool) SetTLSConfig(config *tls.Config) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.tlsConfig = config
+	p.client = nil
+}
+
+// 在 addr 上提供服务, 设置了 TLS 时使用 HTTPS
+func (p *HttpPool) ListenAndServe(addr string) error {
+	p.mutex.Lock()
+	config := p.tlsConfig
+	p.mutex.Unlock()
+	if config == nil {
+		return http.ListenAndServe(addr, p)
+	}
+	server := &http.Server{Addr: addr, Handler: p, TLSConfig: config.Clone()}
+	return server.ListenAndServeTLS("", "")
+}
+
+// 启用双向认证时检查请求方的证书是否属于环上的某个节点
+func (p *HttpPool) authorizePeer(r *http.Request) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.tlsConfig == nil || p.tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		return true
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for peer := range p.httpGetters {
+		u, err := url.Parse(peer)
+		if err != nil {
+			continue
+		}
+		if cert.VerifyHostname(u.Hostname()) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// 访问其他节点使用的 client, 调用方需要持有锁
+func (p *HttpPool) httpClient() *http.Client {
+	if p.client != nil {
+		return p.client
+	}
+	if p.tlsConfig == nil && p.signer == nil {
+		return http.DefaultClient
+	}
+	var transport http.RoundTripper = http.DefaultTransport
+	if p.tlsConfig != nil {
+		transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: p.tlsConfig.Clone(),
+		}
+	}
+	if p.signer != nil {
+		transport = &signingTransport{base: transport, signer: p.signer}
+	}
+	p.client = &http.Client{Transport: transport}
+	return p.client
+}
@@ -0,0 +1,145 @@
+package ruoCache
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 测试时生成的 CA, 可以签发节点证书
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ruoCache test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// 签发一个证书, hosts 可以是 IP 或者域名
+func (ca *testCA) issue(t *testing.T, hosts ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	type node struct {
+		server *httptest.Server
+		pool   *HttpPool
+		group  *Group
+		loads  int
+	}
+	newNode := func() *node {
+		n := &node{}
+		registry := NewRegistry()
+		n.group = registry.NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			n.loads++
+			return []byte("value of " + key), nil
+		}))
+		n.pool = NewHttpPoolWithRegistry("", registry)
+		config := NewPeerTLSConfig(ca.issue(t, "127.0.0.1"), ca.pool)
+		n.pool.SetTLSConfig(config)
+		n.server = httptest.NewUnstartedServer(n.pool)
+		n.server.TLS = config
+		n.server.StartTLS()
+		t.Cleanup(n.server.Close)
+		n.pool.self = n.server.URL
+		n.group.RegisterPeers(n.pool)
+		return n
+	}
+	a, b := newNode(), newNode()
+	a.pool.Set(a.server.URL, b.server.URL)
+	b.pool.Set(a.server.URL, b.server.URL)
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := a.pool.PickPeer(strconv.Itoa(i)); ok {
+			key = strconv.Itoa(i)
+		}
+	}
+	if v, err := a.group.Get(key); err != nil || v.String() != "value of "+key || a.loads != 0 || b.loads != 1 {
+		t.Fatalf("expect the owner to serve %s over mTLS, got %q %v", key, v.String(), err)
+	}
+
+	get := func(config *tls.Config) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		return client.Get(b.server.URL + defaultBasePath + "scores/" + key)
+	}
+
+	// 证书由同一个 CA 签发, 但身份不是环上的节点
+	res, err := get(NewPeerTLSConfig(ca.issue(t, "evil.example"), ca.pool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expect 403 for a certificate outside the ring, got %d", res.StatusCode)
+	}
+
+	// 没有客户端证书
+	if res, err := get(&tls.Config{RootCAs: ca.pool}); err == nil {
+		res.Body.Close()
+		t.Fatalf("expect the handshake to fail without a client certificate")
+	}
+
+	// 其他 CA 签发的证书
+	other := newTestCA(t)
+	if res, err := get(NewPeerTLSConfig(other.issue(t, "127.0.0.1"), ca.pool)); err == nil {
+		res.Body.Close()
+		t.Fatalf("expect the handshake to fail with a certificate from another CA")
+	}
+}
@@ -0,0 +1,108 @@
+// TypedGroup 在 Group 上加了一层编解码, 调用方直接读写 T。
+// 缓存里保存的是编码后的字节, 编码只在写入缓存时做一次, 读取时按 Codec 解码。
+package ruoCache
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang/protobuf/proto"
+)
+
+// T 和缓存中字节之间的编解码
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(b []byte) (T, error)
+}
+
+// 按 JSON 编解码
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// 按 protobuf 编解码, New 返回一个空的消息用来解码
+type ProtoCodec[T proto.Message] struct {
+	New func() T
+}
+
+func (c ProtoCodec[T]) Encode(v T) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+func (c ProtoCodec[T]) Decode(b []byte) (T, error) {
+	v := c.New()
+	err := proto.Unmarshal(b, v)
+	return v, err
+}
+
+// 不做任何转换
+type StringCodec struct{}
+
+func (StringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (StringCodec) Decode(b []byte) (string, error) {
+	return string(b), nil
+}
+
+// 把返回 T 的回调函数转换成 Getter, 从数据源加载时编码一次
+func TypedGetter[T any](codec Codec[T], fn func(key string) (T, error)) Getter {
+	return GetterFunc(func(key string) ([]byte, error) {
+		v, err := fn(key)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Encode(v)
+	})
+}
+
+// 按 codec 解码的 Sink
+type codecSink[T any] struct {
+	codec Codec[T]
+	v     T
+}
+
+func (sink *codecSink[T]) SetBytes(b []byte) (err error) {
+	sink.v, err = sink.codec.Decode(b)
+	return err
+}
+
+// 读写 T 的分组
+type TypedGroup[T any] struct {
+	group *Group
+	codec Codec[T]
+}
+
+// 用 codec 包装一个分组
+func NewTypedGroup[T any](group *Group, codec Codec[T]) *TypedGroup[T] {
+	return &TypedGroup[T]{group: group, codec: codec}
+}
+
+// 底层的分组
+func (t *TypedGroup[T]) Group() *Group {
+	return t.group
+}
+
+// 查找缓存并解码
+func (t *TypedGroup[T]) Get(ctx context.Context, key string) (T, error) {
+	sink := &codecSink[T]{codec: t.codec}
+	err := t.group.GetInto(ctx, key, sink)
+	return sink.v, err
+}
+
+// 编码后写入缓存
+func (t *TypedGroup[T]) Set(key string, v T) error {
+	b, err := t.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return t.group.Set(key, string(b))
+}
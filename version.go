@@ -0,0 +1,96 @@
+// 每个缓存值都带有一个版本号。版本号取当前的纳秒时间, 如果不大于上一个版本号则在其基础上加一,
+// 因此同一个节点上单调递增, 不同节点之间也大致可以比较先后。
+// 迁移和热点复制时版本号不比本地新的写入会被丢弃。
+package ruoCache
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// CompareAndSet 时缓存当前的版本号与期望的不一致
+	ErrVersionMismatch = errors.New("version mismatch")
+	// 写入的版本号不比已有的缓存新
+	ErrStaleVersion = errors.New("stale version")
+)
+
+// 生成新的版本号
+func (g *Group) nextVersion() uint64 {
+	for {
+		last := atomic.LoadUint64(&g.lastVersion)
+		next := uint64(time.Now().UnixNano())
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapUint64(&g.lastVersion, last, next) {
+			return next
+		}
+	}
+}
+
+// 记录其他地方产生的版本号, 保证之后本地生成的版本号比它大
+func (g *Group) observeVersion(version uint64) {
+	for {
+		last := atomic.LoadUint64(&g.lastVersion)
+		if version <= last || atomic.CompareAndSwapUint64(&g.lastVersion, last, version) {
+			return
+		}
+	}
+}
+
+// 缓存当前的版本号为 expectedVersion 时才写入, expectedVersion 为 0 表示 key 必须不存在。
+// 成功时返回新的版本号。
+// 版本号的比较必须和写入缓存一起完成, 所以数据源在缓存之后写入, 写入失败时撤销缓存
+func (g *Group) CompareAndSet(key string, expectedVersion uint64, value string) (uint64, error) {
+	if g.isClosed() {
+		return 0, ErrGroupClosed
+	}
+	unlock := g.keyLocks.lock(key)
+	defer unlock()
+	v := g.newView([]byte(value), g.nextVersion())
+	e, err := g.newEntry(key, v, nil)
+	if err != nil {
+		return 0, err
+	}
+	if !g.mainCache.compareAndAdd(key, expectedVersion, e) {
+		return 0, ErrVersionMismatch
+	}
+	if err := g.store(key, []byte(value)); err != nil {
+		g.mainCache.removeIfVersion(key, v.version)
+		return 0, err
+	}
+	return v.version, nil
+}
+
+// 返回 mainCache 中缓存的版本号, 不会加载数据源。key 没有缓存时 ok 为 false
+func (g *Group) Version(key string) (version uint64, ok bool) {
+	e, ok := g.mainCache.lookup(key)
+	if !ok {
+		return 0, false
+	}
+	return e.value.version, true
+}
+
+// 使用调用方指定的版本号写入, 版本号不比已有的缓存新时返回 ErrStaleVersion
+func (g *Group) SetWithVersion(key, value string, version uint64) error {
+	if g.isClosed() {
+		return ErrGroupClosed
+	}
+	unlock := g.keyLocks.lock(key)
+	defer unlock()
+	e, err := g.newEntry(key, g.newView([]byte(value), version), nil)
+	if err != nil {
+		return err
+	}
+	if !g.mainCache.addIfNewer(key, e) {
+		return ErrStaleVersion
+	}
+	g.observeVersion(version)
+	if err := g.store(key, []byte(value)); err != nil {
+		g.mainCache.removeIfVersion(key, version)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// 业务需要在缓存被写入、删除、过期或淘汰时做出反应。
+// Group.Watch 返回一个事件 channel, 事件由 mainCache 在写入和删除时产生,
+// 淘汰事件来自 lru.Cache 的 OnEvicted 回调。
+// HttpPool 提供 server-sent events 接口, 远程客户端可以订阅一个分组:
+// GET /<basepath>/_watch/<groupname>?key=<key 或以 * 结尾的前缀>
+package ruoCache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	watchPath       = "_watch" // /<basepath>/_watch/<groupname>
+	watchBufferSize = 64       // 消费不及时的事件会被丢弃
+)
+
+// 事件类型
+type EventType int
+
+const (
+	EventSet    EventType = iota // 写入
+	EventRemove                  // 主动删除
+	EventExpire                  // 过期
+	EventEvict                   // 内存不足被淘汰
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventRemove:
+		return "remove"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// 缓存变化的事件
+type Event struct {
+	Type  EventType
+	Key   string
+	Value ByteView // 只有 EventSet 带有写入的值
+
+	entry *cacheEntry // 发布时还没有解压的缓存
+}
+
+// 每个订阅有一个协程把 raw 中的事件解码后转发到 ch,
+// 解密、解压都在这个协程中进行, 不占用发布事件时持有的缓存锁
+type watcher struct {
+	pattern string
+	raw     chan Event // 发布的事件, 还没有解码
+	ch      chan Event // 返回给订阅方
+}
+
+// 解码并转发事件, raw 关闭或 ctx 结束时关闭 ch
+func (w *watcher) forward(ctx context.Context) {
+	defer close(w.ch)
+	for ev := range w.raw {
+		if ev.entry != nil {
+			ev.Value, _ = ev.entry.view(ev.Key)
+			ev.entry = nil
+		}
+		select {
+		case w.ch <- ev:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// key 是否匹配, pattern 以 '*' 结尾时按前缀匹配
+func (w *watcher) match(key string) bool {
+	if strings.HasSuffix(w.pattern, "*") {
+		return strings.HasPrefix(key, w.pattern[:len(w.pattern)-1])
+	}
+	return key == w.pattern
+}
+
+// 管理一个分组的所有订阅
+type watchHub struct {
+	mutex    sync.RWMutex
+	watchers map[*watcher]struct{}
+	done     chan struct{} // 分组关闭时关闭
+	logger   Logger
+}
+
+func newWatchHub(logger Logger) *watchHub {
+	return &watchHub{
+		watchers: make(map[*watcher]struct{}),
+		done:     make(chan struct{}),
+		logger:   logger,
+	}
+}
+
+// 取消订阅, 转发完剩下的事件后关闭 channel
+func (h *watchHub) remove(w *watcher) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.watchers[w]; ok {
+		delete(h.watchers, w)
+		close(w.raw)
+	}
+}
+
+// 关闭所有订阅, 之后的订阅直接返回已关闭的 channel
+func (h *watchHub) close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for w := range h.watchers {
+		delete(h.watchers, w)
+		close(w.raw)
+	}
+	close(h.done)
+}
+
+// 发布事件, 可能在持有缓存锁时调用, 因此不能阻塞, 也不在这里解码
+func (h *watchHub) publish(ev Event) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for w := range h.watchers {
+		if !w.match(ev.Key) {
+			continue
+		}
+		select {
+		case w.raw <- ev:
+		default:
+			logf(h.logger, "[RuoCache] watcher %s is too slow, drop %s event of %s", w.pattern, ev.Type, ev.Key)
+		}
+	}
+}
+
+// 订阅 key 或者前缀 (以 '*' 结尾) 的变化, ctx 结束或分组关闭时 channel 被关闭
+func (g *Group) Watch(ctx context.Context, keyOrPrefix string) <-chan Event {
+	w := &watcher{
+		pattern: keyOrPrefix,
+		raw:     make(chan Event, watchBufferSize),
+		ch:      make(chan Event),
+	}
+	hub := g.watchers
+	hub.mutex.Lock()
+	select {
+	case <-hub.done:
+		hub.mutex.Unlock()
+		close(w.ch)
+		return w.ch
+	default:
+	}
+	hub.watchers[w] = struct{}{}
+	hub.mutex.Unlock()
+
+	go w.forward(ctx)
+	go func() {
+		select {
+		case <-ctx.Done():
+			hub.remove(w)
+		case <-hub.done:
+		}
+	}()
+	return w.ch
+}
+
+// server-sent events 中的数据
+type watchEvent struct {
+	Type    string `json:"type"`
+	Key     string `json:"key"`
+	Version uint64 `json:"version,omitempty"`
+	Value   []byte `json:"value,omitempty"`
+}
+
+// 以 server-sent events 推送分组的变化
+func (p *HttpPool) serveWatch(w http.ResponseWriter, r *http.Request, groupName string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := p.registry.GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	pattern := r.URL.Query().Get("key")
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for ev := range group.Watch(r.Context(), pattern) {
+		data, err := json.Marshal(watchEvent{
+			Type:    ev.Type.String(),
+			Key:     ev.Key,
+			Version: ev.Value.Version(),
+			Value:   ev.Value.contiguous(),
+		})
+		if err != nil {
+			p.Log("encode watch event failed: %v", err)
+			continue
+		}
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,146 @@
+package ruoCache
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"ruoCache/lru"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+	return Event{}
+}
+
+func TestWatch(t *testing.T) {
+	// 能放下两个条目, 再加上 30 字节的 key 和 value
+	cacheBytes := 2*(lru.DefaultEntryOverhead+int64(unsafe.Sizeof(cacheEntry{}))) + 30
+	g := newGroup("watch", cacheBytes, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("loaded"), nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	events := g.Watch(ctx, "user:*")
+	single := g.Watch(ctx, "tom")
+
+	g.Set("user:1", "tom")
+	if ev := nextEvent(t, events); ev.Type != EventSet || ev.Key != "user:1" || ev.Value.String() != "tom" {
+		t.Fatalf("unexpected event %v", ev)
+	}
+	g.Get("user:2") // populateCache
+	if ev := nextEvent(t, events); ev.Type != EventSet || ev.Key != "user:2" || ev.Value.String() != "loaded" {
+		t.Fatalf("unexpected event %v", ev)
+	}
+	g.Delete("user:1")
+	if ev := nextEvent(t, events); ev.Type != EventRemove || ev.Key != "user:1" {
+		t.Fatalf("unexpected event %v", ev)
+	}
+
+	g.mainCache.set("user:3", &cacheEntry{value: ByteView{b: []byte("x")}, expire: time.Now().Add(-time.Second)})
+	nextEvent(t, events)
+	g.mainCache.get("user:3")
+	if ev := nextEvent(t, events); ev.Type != EventExpire || ev.Key != "user:3" {
+		t.Fatalf("unexpected event %v", ev)
+	}
+
+	// tom 和 user:2 的 key 和 value 超过了 30 字节, 写入 tom 会淘汰 user:2
+	g.Set("tom", "01234567890123456789")
+	if ev := nextEvent(t, events); ev.Type != EventEvict || ev.Key != "user:2" {
+		t.Fatalf("unexpected event %v", ev)
+	}
+	if ev := nextEvent(t, single); ev.Type != EventSet || ev.Key != "tom" {
+		t.Fatalf("unexpected event %v", ev)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestServeWatch(t *testing.T) {
+	n := newTestNode(t)
+	res, err := http.Get(n.server.URL + defaultBasePath + watchPath + "/scores?key=user:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	// 等待订阅注册后再写入
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		n.group.watchers.mutex.RLock()
+		registered := len(n.group.watchers.watchers) > 0
+		n.group.watchers.mutex.RUnlock()
+		if registered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("watcher was not registered")
+		}
+	}
+	n.group.Set("jack", "589")
+	n.group.Set("user:1", "tom")
+
+	reader := bufio.NewReader(res.Body)
+	line, _ := reader.ReadString('\n')
+	if line != "event: set\n" {
+		t.Fatalf("unexpected line %q", line)
+	}
+	line, _ = reader.ReadString('\n')
+	if !strings.HasPrefix(line, "data: ") || !strings.Contains(line, `"key":"user:1"`) {
+		t.Fatalf("unexpected data %q", line)
+	}
+}
+
+// 解压时阻塞, 直到 release 被关闭
+type blockingCompressor struct {
+	Compressor
+	release chan struct{}
+}
+
+func (c blockingCompressor) Decompress(b []byte) ([]byte, error) {
+	<-c.release
+	return c.Compressor.Decompress(b)
+}
+
+// 事件在订阅方的协程中解压, 写入不会因为有订阅而在持有锁时解压
+func TestWatchDecodesOutsideLock(t *testing.T) {
+	c := blockingCompressor{Compressor: Flate, release: make(chan struct{})}
+	g, err := NewRegistry().NewGroupWithOptions("watch", GetterFunc(func(key string) ([]byte, error) {
+		return nil, nil
+	}), WithCacheBytes(4<<10), WithCompression(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := g.Watch(context.Background(), "*")
+	value := strings.Repeat("tom", 100)
+
+	done := make(chan struct{})
+	go func() {
+		g.Set("tom", value)
+		g.Set("jack", value) // 第一个事件还在解压, 也不会阻塞
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Set was blocked by decoding the watch event")
+	}
+	close(c.release)
+	if ev := nextEvent(t, events); ev.Key != "tom" || ev.Value.String() != value {
+		t.Fatalf("unexpected event %v", ev)
+	}
+	g.Close()
+	for range events {
+	}
+}
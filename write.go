@@ -0,0 +1,284 @@
+// 没有数据源的分组组成集群时, 写入只保存在收到请求的节点上, 其他节点从负责节点读不到。
+// 这时写入和删除需要交给 key 的负责节点, HttpPool 在读取的地址上提供写入接口:
+// PUT    /<basepath>/<groupname>/<key>?ttl=30s 请求体是原始的值
+// PUT    /<basepath>/<groupname>/<key>?version=1 版本号一致时才写入, 否则返回 412
+// DELETE /<basepath>/<groupname>/<key>         key 没有缓存时返回 404
+// HEAD   /<basepath>/<groupname>/<key>         在 X-Ruocache-Version 中返回版本号, 不会加载数据源
+//
+// REST、RESP 和 memcached 等服务通过 Group.SetOnOwner 等方法写入, key 由其他节点负责时写入负责的节点。
+package ruoCache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 写入或删除时负责 key 的节点出错
+var ErrPeerUnavailable = errors.New("peer unavailable")
+
+// 返回 key 的负责节点, 负责节点是自己、分组没有注册节点或者负责节点不支持写入时返回 false
+func (g *Group) owner(key string) (PeerWriter, bool) {
+	if g.peers == nil {
+		return nil, false
+	}
+	peer, ok := g.peers.PickPeer(key)
+	if !ok {
+		return nil, false
+	}
+	writer, ok := peer.(PeerWriter)
+	return writer, ok
+}
+
+// 写入 key 的负责节点, ttl 大于 0 时代替分组的过期时间。负责节点出错时返回 ErrPeerUnavailable
+func (g *Group) SetOnOwner(key, value string, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if owner, ok := g.owner(key); ok {
+		if err := owner.Set(g.name, key, []byte(value), ttl); err != nil {
+			return fmt.Errorf("%w: write %s to its owner: %v", ErrPeerUnavailable, key, err)
+		}
+		return nil
+	}
+	if err := g.Set(key, value); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		g.Expire(key, ttl)
+	}
+	return nil
+}
+
+// 负责节点上缓存的版本号为 expected 时才写入, 见 Group.CompareAndSet。ttl 大于 0 时代替分组的过期时间
+func (g *Group) CompareAndSetOnOwner(key string, expected uint64, value string, ttl time.Duration) (uint64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("key is required")
+	}
+	if owner, ok := g.owner(key); ok {
+		version, err := owner.CompareAndSet(g.name, key, expected, []byte(value), ttl)
+		if err != nil && !errors.Is(err, ErrVersionMismatch) {
+			return 0, fmt.Errorf("%w: write %s to its owner: %v", ErrPeerUnavailable, key, err)
+		}
+		return version, err
+	}
+	version, err := g.CompareAndSet(key, expected, value)
+	if err != nil {
+		return 0, err
+	}
+	if ttl > 0 {
+		g.Expire(key, ttl)
+	}
+	return version, nil
+}
+
+// 返回负责节点上缓存的版本号, 不会加载数据源。没有缓存时 ok 为 false
+func (g *Group) VersionOnOwner(key string) (uint64, bool, error) {
+	if owner, ok := g.owner(key); ok {
+		version, ok, err := owner.Version(g.name, key)
+		if err != nil {
+			return 0, false, fmt.Errorf("%w: read the version of %s from its owner: %v", ErrPeerUnavailable, key, err)
+		}
+		return version, ok, nil
+	}
+	version, ok := g.Version(key)
+	return version, ok, nil
+}
+
+// 从 key 的负责节点删除, 返回删除之前负责节点上是否缓存了 key
+func (g *Group) DeleteOnOwner(key string) (bool, error) {
+	if owner, ok := g.owner(key); ok {
+		existed, err := owner.Delete(g.name, key)
+		if err != nil {
+			return false, fmt.Errorf("%w: delete %s from its owner: %v", ErrPeerUnavailable, key, err)
+		}
+		return existed, nil
+	}
+	existed := g.Exists(key)
+	if err := g.Delete(key); err != nil {
+		return false, err
+	}
+	return existed, nil
+}
+
+// 其他节点转发过来的写入
+func (p *HttpPool) servePut(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	var ttl time.Duration
+	if s := r.URL.Query().Get("ttl"); s != "" {
+		var err error
+		if ttl, err = time.ParseDuration(s); err != nil || ttl < 0 {
+			http.Error(w, "bad ttl: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+	value, err := ioutil.ReadAll(io.LimitReader(r.Body, maxEntrySize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(value) > maxEntrySize {
+		http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if s := r.URL.Query().Get("version"); s != "" {
+		expected, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "bad version: "+s, http.StatusBadRequest)
+			return
+		}
+		version, err := group.CompareAndSet(key, expected, string(value))
+		if errors.Is(err, ErrVersionMismatch) {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set(versionHeader, strconv.FormatUint(version, 10))
+	} else if err := group.Set(key, string(value)); err != nil {
+		writeError(w, err)
+		return
+	}
+	if ttl > 0 {
+		group.Expire(key, ttl)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 写入失败时的响应, 分组已经关闭时和不存在一样返回 404
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrGroupClosed) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// 其他节点转发过来的删除
+func (p *HttpPool) serveDelete(w http.ResponseWriter, group *Group, key string) {
+	existed := group.Exists(key)
+	if err := group.Delete(key); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !existed {
+		http.Error(w, "key not cached: "+key, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 其他节点读取版本号, 只查看 mainCache
+func (p *HttpPool) serveVersion(w http.ResponseWriter, group *Group, key string) {
+	version, ok := group.Version(key)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set(versionHeader, strconv.FormatUint(version, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// 读写单个 key 的地址
+func (h *httpGetter) keyURL(group, key string) string {
+	return fmt.Sprintf("%v%v/%v", h.baseURL, url.PathEscape(group), url.PathEscape(key))
+}
+
+func (h *httpGetter) Set(group, key string, value []byte, ttl time.Duration) error {
+	u := h.keyURL(group, key)
+	if ttl > 0 {
+		u += "?ttl=" + url.QueryEscape(ttl.String())
+	}
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+// 对方没有这个分组时也当作没有缓存
+func (h *httpGetter) Delete(group, key string) (bool, error) {
+	req, err := http.NewRequest(http.MethodDelete, h.keyURL(group, key), nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := h.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("server returned: %v", res.Status)
+}
+
+func (h *httpGetter) CompareAndSet(group, key string, expected uint64, value []byte, ttl time.Duration) (uint64, error) {
+	u := h.keyURL(group, key) + "?version=" + strconv.FormatUint(expected, 10)
+	if ttl > 0 {
+		u += "&ttl=" + url.QueryEscape(ttl.String())
+	}
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(value))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNoContent:
+		return strconv.ParseUint(res.Header.Get(versionHeader), 10, 64)
+	case http.StatusPreconditionFailed:
+		return 0, ErrVersionMismatch
+	}
+	return 0, fmt.Errorf("server returned: %v", res.Status)
+}
+
+// 对方没有这个分组时也当作没有缓存
+func (h *httpGetter) Version(group, key string) (uint64, bool, error) {
+	req, err := http.NewRequest(http.MethodHead, h.keyURL(group, key), nil)
+	if err != nil {
+		return 0, false, err
+	}
+	res, err := h.client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		version, err := strconv.ParseUint(res.Header.Get(versionHeader), 10, 64)
+		return version, err == nil, err
+	case http.StatusNotFound:
+		return 0, false, nil
+	}
+	return 0, false, fmt.Errorf("server returned: %v", res.Status)
+}
+
+var _ PeerWriter = (*httpGetter)(nil)
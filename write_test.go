@@ -0,0 +1,121 @@
+package ruoCache
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPeerWriter(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t)}
+	urls := []string{nodes[0].server.URL, nodes[1].server.URL}
+	for _, n := range nodes {
+		n.pool.Set(urls...)
+	}
+	key := ""
+	var peer PeerGetter
+	for i := 0; key == ""; i++ {
+		if p, ok := nodes[0].pool.PickPeer("a/b " + strconv.Itoa(i)); ok {
+			key, peer = "a/b "+strconv.Itoa(i), p
+		}
+	}
+	writer, ok := peer.(PeerWriter)
+	if !ok {
+		t.Fatalf("http peers should implement PeerWriter")
+	}
+
+	if err := writer.Set("scores", key, []byte("\x00\xff"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := nodes[1].group.mainCache.get(key); !ok || v.String() != "\x00\xff" {
+		t.Fatalf("owner should store the value, got %q", v)
+	}
+	if ttl, _ := nodes[1].group.TTL(key); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+	if err := writer.Set("missing", key, []byte("x"), 0); err == nil {
+		t.Fatalf("expect an error for an unknown group")
+	}
+
+	if existed, err := writer.Delete("scores", key); err != nil || !existed {
+		t.Fatalf("expect the key to be deleted, got %v %v", existed, err)
+	}
+	if nodes[1].group.Exists(key) {
+		t.Fatalf("owner should no longer cache %s", key)
+	}
+	if existed, err := writer.Delete("scores", key); err != nil || existed {
+		t.Fatalf("expect the second delete to find nothing, got %v %v", existed, err)
+	}
+
+	// 版本号 0 表示 key 必须不存在, 读取版本号时不会加载数据源
+	if _, ok, err := writer.Version("scores", key); err != nil || ok {
+		t.Fatalf("expect no version for a deleted key, got %v %v", ok, err)
+	}
+	version, err := writer.CompareAndSet("scores", key, 0, []byte("v1"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := writer.Version("scores", key); err != nil || !ok || got != version {
+		t.Fatalf("expect version %d, got %d %v %v", version, got, ok, err)
+	}
+	if _, err := writer.CompareAndSet("scores", key, version+1, []byte("v2"), 0); err != ErrVersionMismatch {
+		t.Fatalf("expect ErrVersionMismatch, got %v", err)
+	}
+	if _, err := writer.CompareAndSet("scores", key, version, []byte("v2"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := nodes[1].group.mainCache.get(key); v.String() != "v2" {
+		t.Fatalf("expect v2 on the owner, got %q", v)
+	}
+	if nodes[1].loadCount(key) != 0 {
+		t.Fatalf("writes and version reads should not load %s", key)
+	}
+}
+
+// key 由其他节点负责时写入和删除都交给它, 本节点不缓存
+func TestWriteOnOwner(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t)}
+	urls := []string{nodes[0].server.URL, nodes[1].server.URL}
+	for _, n := range nodes {
+		n.pool.Set(urls...)
+	}
+	var remote, local string
+	for i := 0; remote == "" || local == ""; i++ {
+		key := "key" + strconv.Itoa(i)
+		if _, ok := nodes[0].pool.PickPeer(key); ok {
+			remote = key
+		} else {
+			local = key
+		}
+	}
+
+	for _, key := range []string{remote, local} {
+		if err := nodes[0].group.SetOnOwner(key, "v", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if nodes[0].group.Exists(remote) || !nodes[1].group.Exists(remote) {
+		t.Fatalf("%s should only be cached on its owner", remote)
+	}
+	if ttl, _ := nodes[0].group.TTL(local); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected ttl %v for the local key", ttl)
+	}
+	if existed, err := nodes[0].group.DeleteOnOwner(remote); err != nil || !existed {
+		t.Fatalf("expect %s to be deleted on its owner, got %v %v", remote, existed, err)
+	}
+	if nodes[1].group.Exists(remote) {
+		t.Fatalf("owner should no longer cache %s", remote)
+	}
+	if existed, err := nodes[0].group.DeleteOnOwner(local); err != nil || !existed {
+		t.Fatalf("expect %s to be deleted locally, got %v %v", local, existed, err)
+	}
+
+	nodes[1].server.Close()
+	if err := nodes[0].group.SetOnOwner(remote, "v", 0); !errors.Is(err, ErrPeerUnavailable) {
+		t.Fatalf("expect ErrPeerUnavailable, got %v", err)
+	}
+	if _, err := nodes[0].group.DeleteOnOwner(remote); !errors.Is(err, ErrPeerUnavailable) {
+		t.Fatalf("expect ErrPeerUnavailable, got %v", err)
+	}
+}